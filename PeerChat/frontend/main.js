@@ -3,13 +3,17 @@ let channel;
 let localStream;
 let remoteStream;
 let peerConnection;
-let username = `user_${Math.random().toString(36).substring(2)}_${Date.now()}`;
-let password = "123";
+// the credentials entered in the login form, kept to log in again once the
+// session can no longer be resumed
+let username = null;
+let password = null;
 let selectedChat;
 
 // Track room state
 let isInitiator = false;
 let connectedUsers = new Set();
+// session ID of the peer on the other end of peerConnection
+let remotePeer = null;
 
 // Track state
 let micMuted = false;
@@ -56,9 +60,6 @@ let init = async () => {
 
     // Add controls event listeners
     addVideoControls();
-
-    // Do login
-    await loginWithoutForm();
   } catch (error) {
     console.error("Error in init:", error);
   }
@@ -209,11 +210,6 @@ function connectWebsocket(otp) {
       console.log("WebSocket connection established");
 
       // Join room and process any queued messages
-      sendEvent("join_room", {
-        room: roomId,
-        username: username,
-      });
-
       changeChatRoomWithoutdata();
       processMessageQueue();
     };
@@ -251,8 +247,14 @@ let reconnectTimeout = null;
 const RECONNECT_DELAY = 3000;
 const MAX_RECONNECT_ATTEMPTS = 5;
 let reconnectAttempts = 0;
+// serverRetryAfter is the delay the server asked for in server_shutdown.
+let serverRetryAfter = 0;
 
 function attemptReconnect() {
+  // nothing to reconnect before logging in through the form
+  if (!username) {
+    return;
+  }
   if (reconnectTimeout) {
     clearTimeout(reconnectTimeout);
   }
@@ -263,6 +265,9 @@ function attemptReconnect() {
     return;
   }
 
+  const delay = Math.max(RECONNECT_DELAY, serverRetryAfter);
+  serverRetryAfter = 0;
+
   reconnectTimeout = setTimeout(() => {
     console.log(
       `Attempting to reconnect... (${
@@ -271,7 +276,7 @@ function attemptReconnect() {
     );
     reconnectAttempts++;
 
-    loginWithoutForm()
+    loginWithCredentials()
       .then(() => {
         console.log("Reconnection successful");
         reconnectAttempts = 0;
@@ -280,7 +285,7 @@ function attemptReconnect() {
         console.error("Reconnection failed:", error);
         attemptReconnect();
       });
-  }, RECONNECT_DELAY);
+  }, delay);
 }
 
 function changeChatRoomWithoutdata() {
@@ -292,33 +297,70 @@ function changeChatRoomWithoutdata() {
     let changeEvent = new ChangeChatRoomEvent(selectedChat);
     sendEvent("change_room", changeEvent);
 
+    // local media is set up in init before login, so we are ready to connect
+    sendEvent("user_ready", { room: roomId });
+
     textarea = document.getElementById("chatmessages");
     textarea.innerHTML = `You changed room into: ${selectedChat}`;
   }
   return false;
 }
 
-function connectWebsocket(otp) {
+// session holds the resume token from the last session event, resumeDeadline is
+// until when a dropped websocket may still be resumed with it.
+let session = null;
+let resumeDeadline = null;
+
+// resumeSession reconnects with the resume token, so we keep our session ID, room
+// and call and get the events sent to us meanwhile. It returns false once it is too late.
+function resumeSession() {
+  if (!session) {
+    return false;
+  }
+  if (!resumeDeadline) {
+    resumeDeadline = Date.now() + session.resume_within_ms;
+  }
+  if (Date.now() >= resumeDeadline) {
+    session = null;
+    resumeDeadline = null;
+    return false;
+  }
+  setTimeout(() => connectWebsocket(null, session.token), RECONNECT_DELAY);
+  return true;
+}
+
+function connectWebsocket(otp, resumeToken) {
   if (window["WebSocket"]) {
-    conn = new WebSocket("wss://" + document.location.host + "/ws?otp=" + otp);
+    const query = resumeToken ? "resume=" + resumeToken : "otp=" + otp;
+    conn = new WebSocket("wss://" + document.location.host + "/ws?" + query);
 
     conn.onopen = function (evt) {
       document.getElementById("connection-header").innerHTML =
         "Connected to Websocket = true";
 
-      // Now that connection is open, join room and change chat room
-      sendEvent("join_room", {
-        room: roomId,
-        username: username,
-      });
+      // a resumed session is still in the room, with the call still up
+      if (resumeToken) {
+        return;
+      }
 
-      // Change chat room after joining
+      // Now that connection is open, join the chat room
       changeChatRoomWithoutdata();
     };
 
     conn.onclose = function (evt) {
       document.getElementById("connection-header").innerHTML =
         "Connected to Websocket = false";
+
+      // after server_shutdown or 4001 (session unknown to the server) log in again
+      if (serverRetryAfter || evt.code === 4001) {
+        session = null;
+        resumeDeadline = null;
+        attemptReconnect();
+        return;
+      }
+      if (evt.code !== 1000 && !resumeSession()) {
+        attemptReconnect();
+      }
     };
 
     conn.onmessage = function (evt) {
@@ -331,7 +373,8 @@ function connectWebsocket(otp) {
   }
 }
 
-function loginWithoutForm() {
+// loginWithCredentials logs in with the credentials of the login form and connects.
+function loginWithCredentials() {
   return new Promise((resolve, reject) => {
     let formData = {
       username: username,
@@ -375,55 +418,93 @@ function routeEvent(event) {
       const messageEvent = Object.assign(new NewMessageEvent(), event.payload);
       appendChatMessage(messageEvent);
       break;
+    case "room_history":
+      event.payload.messages.forEach((message) => {
+        appendChatMessage(Object.assign(new NewMessageEvent(), message));
+      });
+      break;
     case "user_join":
       const joinEvent = Object.assign(new UserJoinEvent(), event.payload);
       handleUserJoin(joinEvent);
       break;
+    case "peer_left":
+      handlePeerLeft(event.payload);
+      break;
     case "user_ready":
       handleUserReady(event.payload);
       break;
     case "offer":
-      handleOffer(event.payload.offer, event.payload.from);
+      handleOffer(
+        { type: "offer", sdp: event.payload.sdp },
+        event.payload.from
+      );
+      break;
+    case "error":
+      console.warn("server error:", event.payload.code, event.payload.message);
       break;
     case "answer":
-      handleAnswer(event.payload.answer);
+      handleAnswer({ type: "answer", sdp: event.payload.sdp });
       break;
     case "ice_candidate":
       handleIceCandidate(event.payload.candidate);
       break;
+    case "session":
+      session = event.payload;
+      resumeDeadline = null;
+      break;
+    case "server_shutdown":
+      console.log("Server shutting down:", event.payload);
+      serverRetryAfter = event.payload.retry_after_ms;
+      break;
+    case "ice_config":
+      applyIceConfig(event.payload);
+      if (peerConnection) {
+        peerConnection.setConfiguration(servers);
+      }
+      break;
     default:
       console.warn("unsupported message type:", event.type);
       break;
   }
 }
 
-// New function to handle user ready signals
+// Use the ICE servers the backend hands out and renew the TURN credentials
+// a minute before they expire.
+function applyIceConfig(payload) {
+  servers.iceServers = payload.ice_servers;
+  if (payload.expires_at) {
+    const renewIn = new Date(payload.expires_at) - Date.now() - 60 * 1000;
+    setTimeout(() => sendEvent("ice_config", {}), Math.max(renewIn, 0));
+  }
+}
+
+// The server tells peers that were ready first to offer to the newcomer
 function handleUserReady(payload) {
-  if (payload.username !== username) {
-    // Only the first user in the room should initiate connections
-    const shouldInitiate = Array.from(connectedUsers).sort()[0] === username;
+  if (payload.offer) {
+    console.log("Initiating connection with:", payload.username);
+    remotePeer = payload.session_id;
+    createOffer(payload.session_id);
+  }
+}
 
-    if (shouldInitiate) {
-      console.log("Initiating connection with:", payload.username);
-      createOffer();
-    }
+// Tear down the call when the other side leaves so the next peer can connect
+async function handlePeerLeft(payload) {
+  connectedUsers.delete(payload.username);
+  if (payload.session_id !== remotePeer) {
+    return;
   }
+  remotePeer = null;
+  document.getElementById("user-2").style.display = "none";
+  document.getElementById("user-1").classList.remove("smallFrame");
+  remoteStream = new MediaStream();
+  document.getElementById("user-2").srcObject = remoteStream;
+  await resetConnection();
 }
 
 // New function to handle user joins
 function handleUserJoin(joinEvent) {
   appendJoinMessage(joinEvent);
 
-  // Don't create offer for our own join event
-  if (joinEvent.username === username) {
-    // We just joined, send ready signal
-    sendEvent("user_ready", {
-      username: username,
-      room: roomId,
-    });
-    return;
-  }
-
   // Add to connected users
   connectedUsers.add(joinEvent.username);
 }
@@ -454,9 +535,7 @@ async function createOffer(targetUser) {
     await peerConnection.setLocalDescription(offer);
 
     sendEvent("offer", {
-      offer: offer,
-      room: roomId,
-      from: username,
+      sdp: offer.sdp,
       to: targetUser, // Specify who should receive this offer
     });
   } catch (error) {
@@ -505,21 +584,22 @@ function attachPeerConnectionHandlers() {
     if (event.candidate && conn && conn.readyState === WebSocket.OPEN) {
       sendEvent("ice_candidate", {
         candidate: event.candidate,
-        room: roomId,
+        to: remotePeer,
       });
     }
   };
 }
 
-async function handleOffer(offer) {
+async function handleOffer(offer, from) {
   try {
+    remotePeer = from;
     await peerConnection.setRemoteDescription(new RTCSessionDescription(offer));
     const answer = await peerConnection.createAnswer();
     await peerConnection.setLocalDescription(answer);
 
     sendEvent("answer", {
-      answer: answer,
-      room: roomId,
+      sdp: answer.sdp,
+      to: from,
     });
   } catch (error) {
     console.error("Error handling offer:", error);
@@ -575,9 +655,8 @@ setInterval(() => {
 }, 5000);
 
 window.onload = function () {
-  document.getElementById("chatroom-selection").onsubmit = changeChatRoom;
-  document.getElementById("chatroom-message").onsubmit = sendMessage;
   document.getElementById("login-form").onsubmit = login;
+  document.getElementById("register-btn").onclick = register;
   init();
 };
 
@@ -597,26 +676,42 @@ window.onbeforeunload = function () {
 };
 
 function login() {
+  username = document.getElementById("username").value;
+  password = document.getElementById("password").value;
+
+  loginWithCredentials()
+    .then(() => {
+      // we are authenticated
+      document.getElementById("login-form").style.display = "none";
+    })
+    .catch((e) => {
+      username = null;
+      password = null;
+      alert(e);
+    });
+  return false;
+}
+
+function register() {
   let formData = {
     username: document.getElementById("username").value,
     password: document.getElementById("password").value,
   };
 
-  fetch("login", {
+  fetch("register", {
     method: "post",
     body: JSON.stringify(formData),
     mode: "cors",
   })
     .then((response) => {
       if (response.ok) {
-        return response.json();
-      } else {
-        throw "unauthorized";
+        // account created, log straight in with the same credentials
+        login();
+        return;
       }
-    })
-    .then((data) => {
-      // we are authenticated
-      connectWebsocket(data.otp);
+      return response.text().then((text) => {
+        throw text || "registration failed";
+      });
     })
     .catch((e) => {
       alert(e);
@@ -202,7 +202,10 @@ function routeEvent(event) {
       break;
     case "new_peer":
       console.log("New Peer:", event.payload);
-      createOffer(event.payload.user_id);
+      createOffer(event.payload.session_id);
+      break;
+    case "peer_left":
+      console.log("Peer Left:", event.payload);
       break;
     case "offer":
       console.log("Offer:", event.payload);
@@ -216,12 +219,29 @@ function routeEvent(event) {
       console.log("ICE Candidate:", event.payload);
       handleIceCandidate(event);
       break;
+    case "server_shutdown":
+      // the server closes the websocket right after this
+      shutdownNotice = event.payload;
+      break;
+    case "ice_config":
+      applyIceConfig(event.payload);
+      break;
     default:
       console.warn("unsupported message type:", event.type);
       break;
   }
 }
 
+// Use the ICE servers the backend hands out and renew the TURN credentials
+// a minute before they expire.
+function applyIceConfig(payload) {
+  servers.iceServers = payload.ice_servers;
+  if (payload.expires_at) {
+    const renewIn = new Date(payload.expires_at) - Date.now() - 60 * 1000;
+    setTimeout(() => sendEvent("ice_config", {}), Math.max(renewIn, 0));
+  }
+}
+
 function handleNewPeer(event) {
   console.log("Handling Offer:", event);
 
@@ -240,6 +260,9 @@ function handleNewPeer(event) {
   };
 }
 
+// shutdownNotice is the server_shutdown payload received before the server closed the websocket.
+let shutdownNotice = null;
+
 function connectWebsocket(otp) {
   if (!window["WebSocket"]) {
     alert("Not supporting websockets");
@@ -258,6 +281,12 @@ function connectWebsocket(otp) {
 
     conn.onclose = function (evt) {
       isConnected = false;
+      if (shutdownNotice) {
+        const seconds = Math.ceil(shutdownNotice.retry_after_ms / 1000);
+        alert(`Server is restarting, log in again in ${seconds}s`);
+        shutdownNotice = null;
+        return;
+      }
       alert("Disconnected from WebSocket");
     };
 
@@ -285,8 +314,8 @@ function login() {
   fetch("login", {
     method: "post",
     body: JSON.stringify({
-      username: "ardhi",
-      password: "123",
+      username: prompt("Username"),
+      password: prompt("Password"),
     }),
     mode: "cors",
   })
@@ -1,10 +1,17 @@
 let localStream;
 let userId;
 const peerConnections = new Map();
+// Perfect negotiation: the server tells us per peer whether we are the polite one.
+// negotiations holds the makingOffer/ignoreOffer flags of each connection.
+const politePeers = new Map();
+const negotiations = new Map();
 
 let queryString = window.location.search;
 let urlParams = new URLSearchParams(queryString);
 let roomId = urlParams.get("room");
+// "sfu" asks for a room where media goes through the server, it only applies
+// when this join creates the room, room_info tells the mode actually in use.
+let roomMode = urlParams.get("mode") || "mesh";
 
 const servers = {
   iceServers: [
@@ -43,6 +50,36 @@ function initializeUser() {
   document.querySelector(".user-label").textContent = userId;
 }
 
+// Sharing the screen adds a track to every connection, each one renegotiates on its own.
+async function shareScreen() {
+  try {
+    const screenStream = await navigator.mediaDevices.getDisplayMedia({
+      video: true,
+    });
+    const [screenTrack] = screenStream.getVideoTracks();
+    peerConnections.forEach((peerConnection, peerId) => {
+      if (peerId === "recorder") {
+        return;
+      }
+      const sender = peerConnection.addTrack(screenTrack, screenStream);
+      screenTrack.addEventListener("ended", () =>
+        peerConnection.removeTrack(sender)
+      );
+    });
+  } catch (error) {
+    console.error("Error sharing screen:", error);
+  }
+}
+
+// Only the room's owner may record, the server answers anyone else with an error.
+function startRecording() {
+  sendEvent("start_recording", {});
+}
+
+function stopRecording() {
+  sendEvent("stop_recording", {});
+}
+
 function createVideoElement(peerID) {
   const videoContainer = document.createElement("div");
   videoContainer.className = "video-container";
@@ -64,10 +101,52 @@ function createVideoElement(peerID) {
   return video;
 }
 
+// The server's own peers ("sfu", "recorder") never roll back their offers,
+// so we are polite towards them and towards anyone we have no role for yet.
+function isPolite(peerId) {
+  return politePeers.has(peerId) ? politePeers.get(peerId) : true;
+}
+
+function negotiationState(peerId) {
+  if (!negotiations.has(peerId)) {
+    negotiations.set(peerId, { makingOffer: false, ignoreOffer: false });
+  }
+  return negotiations.get(peerId);
+}
+
+async function makeOffer(peerId) {
+  const peerConnection = peerConnections.get(peerId);
+  if (!peerConnection) {
+    return;
+  }
+
+  const state = negotiationState(peerId);
+  try {
+    state.makingOffer = true;
+    await peerConnection.setLocalDescription();
+    sendEvent(
+      "offer",
+      new OfferEvent("offer", userId, peerId, peerConnection.localDescription.sdp)
+    );
+  } catch (err) {
+    console.error("Error creating offer:", err);
+  } finally {
+    state.makingOffer = false;
+  }
+}
+
+// Ask the peer to send us an offer that restarts ICE, e.g. after our network changed.
+function requestIceRestart(peerId) {
+  sendEvent("ice_restart", { to: peerId });
+}
+
 function initPeerConnection(peerId) {
   const peerConnection = new RTCPeerConnection(servers);
   peerConnections.set(peerId, peerConnection);
 
+  // adding or removing tracks, e.g. a screen share, and restartIce() land here
+  peerConnection.onnegotiationneeded = () => makeOffer(peerId);
+
   if (localStream) {
     localStream.getTracks().forEach((track) => {
       peerConnection.addTrack(track, localStream);
@@ -76,11 +155,22 @@ function initPeerConnection(peerId) {
 
   peerConnection.ontrack = (event) => {
     if (event.streams && event.streams[0]) {
-      let videoElement = document.getElementById(`video-${peerId}`);
+      const stream = event.streams[0];
+      // the SFU names each forwarded stream after the session that publishes it
+      const owner = peerId === "sfu" ? stream.id : peerId;
+      let videoElement = document.getElementById(`video-${owner}`);
       if (!videoElement) {
-        videoElement = createVideoElement(peerId);
+        videoElement = createVideoElement(owner);
       }
-      videoElement.srcObject = event.streams[0];
+      videoElement.srcObject = stream;
+      stream.onremovetrack = () => {
+        if (stream.getTracks().length === 0) {
+          const container = document.getElementById(`container-${owner}`);
+          if (container) {
+            container.remove();
+          }
+        }
+      };
     }
   };
 
@@ -111,11 +201,11 @@ function initPeerConnection(peerId) {
       `ICE Connection State with ${peerId}:`,
       peerConnection.iceConnectionState
     );
-    if (
-      peerConnection.iceConnectionState === "disconnected" ||
-      peerConnection.iceConnectionState === "failed" ||
-      peerConnection.iceConnectionState === "closed"
-    ) {
+    // "disconnected" often recovers on its own, a failed connection is restarted
+    // instead of being torn down
+    if (peerConnection.iceConnectionState === "failed") {
+      requestIceRestart(peerId);
+    } else if (peerConnection.iceConnectionState === "closed") {
       removeConnection(peerId);
     }
   };
@@ -134,6 +224,8 @@ function removeConnection(peerId) {
     peerConnection.close();
     peerConnections.delete(peerId);
   }
+  negotiations.delete(peerId);
+  politePeers.delete(peerId);
 }
 
 async function handleOffer(to) {
@@ -147,15 +239,9 @@ async function handleOffer(to) {
     return;
   }
 
-  const peerConnection = initPeerConnection(to);
-
-  try {
-    const offer = await peerConnection.createOffer();
-    await peerConnection.setLocalDescription(offer);
-
-    await sendEvent("offer", new OfferEvent("offer", userId, to, offer.sdp));
-  } catch (err) {
-    console.error("Error creating offer:", error);
+  // adding our tracks fires negotiationneeded, which sends the offer
+  if (!peerConnections.has(to)) {
+    initPeerConnection(to);
   }
 }
 
@@ -165,19 +251,31 @@ async function handleAnswer(peerId, to, offer) {
     return;
   }
 
-  const peerConnection = initPeerConnection(to);
+  // the SFU renegotiates on the connection it already has with us
+  const peerConnection = peerConnections.get(to) || initPeerConnection(to);
+  const state = negotiationState(to);
+
+  // both sides offered at once: the impolite side ignores the other offer,
+  // the polite side rolls its own back when applying it
+  const collision =
+    state.makingOffer || peerConnection.signalingState !== "stable";
+  state.ignoreOffer = !isPolite(to) && collision;
+  if (state.ignoreOffer) {
+    console.log("Ignoring colliding offer from", to);
+    return;
+  }
+
   try {
     await peerConnection.setRemoteDescription({
       type: "offer",
       sdp: offer,
     });
 
-    const answer = await peerConnection.createAnswer();
-    await peerConnection.setLocalDescription(answer);
+    await peerConnection.setLocalDescription();
 
     await sendEvent(
       "answer",
-      new AnswerEvent("answer", peerId, to, answer.sdp)
+      new AnswerEvent("answer", peerId, to, peerConnection.localDescription.sdp)
     );
   } catch (err) {
     console.error("Error creating answer:", err);
@@ -211,10 +309,11 @@ class Event {
 }
 
 class JoinRoomEvent {
-  constructor(type, room, userId) {
+  constructor(type, room, userId, mode) {
     this.type = type;
     this.room = room;
     this.userId = userId;
+    this.mode = mode;
   }
 }
 
@@ -288,10 +387,22 @@ function routeEvent(event) {
       break;
     case "room_info":
       console.log("Room Info:", event.payload);
+      roomMode = event.payload.mode;
+      event.payload.users.forEach((user) =>
+        politePeers.set(user.session_id, user.polite)
+      );
       break;
     case "new_peer":
       console.log("New Peer:", event.payload);
-      handleOffer(event.payload.user_id);
+      politePeers.set(event.payload.session_id, event.payload.polite);
+      // in an SFU room the server offers us the newcomer's tracks
+      if (roomMode !== "sfu") {
+        handleOffer(event.payload.session_id);
+      }
+      break;
+    case "peer_left":
+      console.log("Peer Left:", event.payload);
+      removeConnection(event.payload.session_id);
       break;
     case "offer":
       console.log("Offer:", event.payload);
@@ -308,12 +419,55 @@ function routeEvent(event) {
       const payload = event.payload;
       handleIce(payload);
       break;
+    case "renegotiate":
+      console.log("Renegotiate:", event.payload);
+      makeOffer(event.payload.from);
+      break;
+    case "ice_restart":
+      console.log("ICE restart:", event.payload);
+      if (peerConnections.has(event.payload.from)) {
+        peerConnections.get(event.payload.from).restartIce();
+      }
+      break;
+    case "recording_started":
+      // the recorder's offer follows, it is answered like any other
+      console.log("Recording started:", event.payload);
+      break;
+    case "recording_stopped":
+      console.log("Recording stopped:", event.payload);
+      removeConnection("recorder");
+      break;
+    case "error":
+      console.warn("Error:", event.payload);
+      break;
+    case "session":
+      session = event.payload;
+      resumeDeadline = null;
+      break;
+    case "server_shutdown":
+      // the server closes the websocket right after this
+      shutdownNotice = event.payload;
+      break;
+    case "ice_config":
+      applyIceConfig(event.payload);
+      peerConnections.forEach((pc) => pc.setConfiguration(servers));
+      break;
     default:
       console.warn("unsupported message type:", event.type);
       break;
   }
 }
 
+// Use the ICE servers the backend hands out and renew the TURN credentials
+// a minute before they expire.
+function applyIceConfig(payload) {
+  servers.iceServers = payload.ice_servers;
+  if (payload.expires_at) {
+    const renewIn = new Date(payload.expires_at) - Date.now() - 60 * 1000;
+    setTimeout(() => sendEvent("ice_config", {}), Math.max(renewIn, 0));
+  }
+}
+
 async function handleIce(payload) {
   const peerConn = peerConnections.get(payload.from);
 
@@ -327,8 +481,8 @@ async function handleIce(payload) {
     if (payload.candidate) {
       const candidateObj = {
         candidate: payload.candidate.candidate,
-        sdpMid: payload.candidate.sdp_mid,
-        sdpMLineIndex: payload.candidate.sdp_m_line_index,
+        sdpMid: payload.candidate.sdpMid,
+        sdpMLineIndex: payload.candidate.sdpMLineIndex,
       };
 
       console.log("Creating ICE candidate with:", candidateObj);
@@ -336,28 +490,80 @@ async function handleIce(payload) {
       await peerConn.addIceCandidate(candidate);
     }
   } catch (err) {
-    console.error("Error adding ICE candidate:", err, payload.candidate);
+    // candidates of an offer we ignored are expected to fail
+    if (!negotiationState(payload.from).ignoreOffer) {
+      console.error("Error adding ICE candidate:", err, payload.candidate);
+    }
+  }
+}
+
+// shutdownNotice is the server_shutdown payload received before the server closed the websocket.
+let shutdownNotice = null;
+// session holds the resume token from the last session event, resumeDeadline is
+// until when a dropped websocket may still be resumed with it.
+let session = null;
+let resumeDeadline = null;
+const RESUME_DELAY = 1000;
+
+// Reconnect with the session's resume token, so we keep our session ID and room
+// and get the events sent to us meanwhile. Returns false once it is too late.
+function resumeSession() {
+  if (!session) {
+    return false;
+  }
+  if (!resumeDeadline) {
+    resumeDeadline = Date.now() + session.resume_within_ms;
+  }
+  if (Date.now() >= resumeDeadline) {
+    session = null;
+    resumeDeadline = null;
+    return false;
   }
+  setTimeout(() => connectWebsocket(null, session.token), RESUME_DELAY);
+  return true;
 }
 
-function connectWebsocket(otp) {
+function connectWebsocket(otp, resumeToken) {
   if (!window["WebSocket"]) {
     alert("Not supporting websockets");
     return;
   }
 
   try {
-    conn = new WebSocket("wss://" + document.location.host + "/ws?otp=" + otp);
+    const query = resumeToken ? "resume=" + resumeToken : "otp=" + otp;
+    conn = new WebSocket("wss://" + document.location.host + "/ws?" + query);
 
     conn.onopen = function (evt) {
       isConnected = true;
+      // a resumed session is still in its room
+      if (resumeToken) {
+        return;
+      }
       // Join room and process any queued messages
-      let changeEvent = new JoinRoomEvent("join_room", roomId, otp);
+      let changeEvent = new JoinRoomEvent("join_room", roomId, otp, roomMode);
       sendEvent("join_room", changeEvent);
     };
 
     conn.onclose = function (evt) {
       isConnected = false;
+      if (shutdownNotice) {
+        const seconds = Math.ceil(shutdownNotice.retry_after_ms / 1000);
+        alert(`Server is restarting, log in again in ${seconds}s`);
+        shutdownNotice = null;
+        session = null;
+        return;
+      }
+      // 4001: the server no longer knows our session
+      if (evt.code === 4001) {
+        session = null;
+        resumeDeadline = null;
+        alert("Session expired, please log in again");
+        return;
+      }
+      if (evt.code !== 1000 && resumeSession()) {
+        console.log("WebSocket dropped, resuming session");
+        return;
+      }
       alert("Disconnected from WebSocket");
     };
 
@@ -385,8 +591,8 @@ function login() {
   fetch("login", {
     method: "post",
     body: JSON.stringify({
-      username: "ardhi",
-      password: "123",
+      username: prompt("Username"),
+      password: prompt("Password"),
     }),
     mode: "cors",
   })
@@ -400,7 +606,7 @@ function login() {
     .then((data) => {
       // we are authenticated
       connectWebsocket(data.otp);
-      userId = data.otp;
+      userId = data.username;
       console.log(userId);
     })
     .catch((e) => {
@@ -1,5 +1,7 @@
 // selectedchat is by default General.
 var selectedChat = "general";
+// currentUser is the username returned by the login endpoint.
+var currentUser;
 
 class Event {
   constructor(type, payload) {
@@ -39,21 +41,85 @@ function routeEvent(event) {
       const messageEvent = Object.assign(new NewMessageEvent(), event.payload);
       appendChatMessage(messageEvent);
       break;
+    case "room_history":
+      appendRoomHistory(event.payload);
+      break;
+    case "user_join":
+      appendNotice(event.payload.joined_at, `${event.payload.username} joined`);
+      break;
+    case "peer_left":
+      appendNotice(Date.now(), `${event.payload.username} left`);
+      break;
+    case "room_info":
+    case "ice_config":
+      // the text chat opens no peer connections
+      break;
+    case "session":
+      session = event.payload;
+      resumeDeadline = null;
+      break;
+    case "server_shutdown":
+      // the server closes the websocket right after this
+      shutdownNotice = event.payload;
+      break;
+    case "error":
+      console.warn("server error:", event.payload.code, event.payload.message);
+      break;
     default:
-      alert("unsupported message type");
+      console.warn("unsupported message type:", event.type);
       break;
   }
 }
 
 function appendChatMessage(messageEvent) {
-  var date = new Date(messageEvent.sent);
-  const formattedMsg = `${date.toLocaleString()}: ${messageEvent.message}`;
+  const formattedMsg = formatChatMessage(messageEvent);
 
   textarea = document.getElementById("chatmessages");
   textarea.innerHTML = textarea.innerHTML + "\n" + formattedMsg;
   textarea.scrollTop = textarea.scrollHeight;
 }
 
+function appendNotice(sent, notice) {
+  appendChatMessage({ sent: sent, message: notice });
+}
+
+// oldest cursor returned by the server, used to page back through history.
+var historyCursor = 0;
+var loadingOlder = false;
+
+function formatChatMessage(messageEvent) {
+  var date = new Date(messageEvent.sent);
+  return `${date.toLocaleString()}: ${messageEvent.message}`;
+}
+
+function appendRoomHistory(history) {
+  historyCursor = history.cursor;
+  const lines = history.messages.map((message) =>
+    formatChatMessage(Object.assign(new NewMessageEvent(), message))
+  );
+
+  textarea = document.getElementById("chatmessages");
+  if (loadingOlder) {
+    // older pages go above what is already shown
+    textarea.innerHTML = lines.join("\n") + "\n" + textarea.innerHTML;
+    loadingOlder = false;
+    return;
+  }
+  textarea.innerHTML = textarea.innerHTML + "\n" + lines.join("\n");
+  textarea.scrollTop = textarea.scrollHeight;
+}
+
+/**
+ * loadOlderMessages asks the server for the page before the oldest loaded message
+ * */
+function loadOlderMessages() {
+  if (historyCursor) {
+    loadingOlder = true;
+    sendEvent("load_history", { before: historyCursor });
+  }
+  return false;
+}
+
 function sendEvent(eventName, payload) {
   const event = new Event(eventName, payload);
 
@@ -89,7 +155,7 @@ function sendMessage() {
   if (newmessage != null) {
     // console.log(newmessage);
     // conn.send(newmessage.value);
-    let outgoundEvent = new SendMessageEvent(newmessage.value, "ardhi");
+    let outgoundEvent = new SendMessageEvent(newmessage.value, currentUser);
     sendEvent("send_message", outgoundEvent);
   }
   return false;
@@ -115,6 +181,7 @@ function login() {
     })
     .then((data) => {
       // we are authenticated
+      currentUser = data.username;
       connectWebsocket(data.otp);
     })
     .catch((e) => {
@@ -123,11 +190,46 @@ function login() {
   return false;
 }
 
-function connectWebsocket(otp) {
+function register() {
+  let formData = {
+    username: document.getElementById("username").value,
+    password: document.getElementById("password").value,
+  };
+
+  fetch("register", {
+    method: "post",
+    body: JSON.stringify(formData),
+    mode: "cors",
+  })
+    .then((response) => {
+      if (response.ok) {
+        // account created, log straight in with the same credentials
+        login();
+        return;
+      }
+      return response.text().then((text) => {
+        throw text || "registration failed";
+      });
+    })
+    .catch((e) => {
+      alert(e);
+    });
+  return false;
+}
+
+// shutdownNotice is the server_shutdown payload received before the server closed the websocket.
+let shutdownNotice = null;
+// session holds the resume token from the last session event, resumeDeadline is
+// until when a dropped websocket may still be resumed with it.
+let session = null;
+let resumeDeadline = null;
+
+function connectWebsocket(otp, resumeToken) {
   if (window["WebSocket"]) {
     console.log("supports websockets");
-    // connect to websocket
-    conn = new WebSocket("wss://" + document.location.host + "/ws?otp=" + otp);
+    // connect to websocket, resuming keeps our room and the messages sent meanwhile
+    const query = resumeToken ? "resume=" + resumeToken : "otp=" + otp;
+    conn = new WebSocket("wss://" + document.location.host + "/ws?" + query);
 
     conn.onopen = function (evt) {
       document.getElementById("connection-header").innerHTML =
@@ -135,9 +237,28 @@ function connectWebsocket(otp) {
     };
 
     conn.onclose = function (evt) {
+      if (shutdownNotice) {
+        document.getElementById("connection-header").innerHTML =
+          "Server is restarting, reconnect in " +
+          Math.ceil(shutdownNotice.retry_after_ms / 1000) +
+          "s";
+        shutdownNotice = null;
+        session = null;
+        return;
+      }
       document.getElementById("connection-header").innerHTML =
         "Connected to Websocket = false";
-      // reconnection
+      // reconnection, 4001 means the server no longer knows our session
+      if (evt.code === 4001 || evt.code === 1000 || !session) {
+        session = null;
+        return;
+      }
+      if (!resumeDeadline) {
+        resumeDeadline = Date.now() + session.resume_within_ms;
+      }
+      if (Date.now() < resumeDeadline) {
+        setTimeout(() => connectWebsocket(null, session.token), 1000);
+      }
     };
 
     conn.onmessage = function (evt) {
@@ -162,6 +283,8 @@ window.onload = function () {
   document.getElementById("chatroom-selection").onsubmit = changeChatRoom;
   document.getElementById("chatroom-message").onsubmit = sendMessage;
   document.getElementById("login-form").onsubmit = login;
+  document.getElementById("register-btn").onclick = register;
+  document.getElementById("load-older").onclick = loadOlderMessages;
 
   // Check if the browser supports WebSocket
 };
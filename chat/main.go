@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
-	"log"
 	"log/slog"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/joho/godotenv"
-	"github.com/zenk41/learn-webrtc/chat/db"
+	"github.com/zenk41/learn-webrtc/signaling"
+	"github.com/zenk41/learn-webrtc/signaling/config"
 )
 
 func init() {
@@ -25,24 +27,15 @@ func init() {
 
 func main() {
 
-	rootCtx := context.Background()
-	ctx, cancel := context.WithCancel(rootCtx)
+	// everything logs through the configured handler, the pgx tracer and the log package too
+	slog.SetDefault(slog.New(signaling.NewLogHandler(config.LoadLogConfig(), os.Stderr)))
 
-	dbPool, err := db.InitDB()
-	if err != nil {
-		panic(err)
-	}
-	defer dbPool.Close()
-
-	defer cancel()
-	setupAPI(ctx)
-	log.Fatal(http.ListenAndServeTLS(":9090", "server.crt", "server.key", nil))
-}
-
-func setupAPI(ctx context.Context) {
+	// SIGINT and SIGTERM start a graceful shutdown, another one during it ends the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
 
-	manager := newManager(ctx)
-	http.Handle("/", http.FileServer(http.Dir("./frontend")))
-	http.HandleFunc("/ws", manager.serveWS)
-	http.HandleFunc("/login", manager.loginHandler)
+	if err := signaling.Run(ctx, "./frontend"); err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,227 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zenk41/learn-webrtc/signaling/db"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// maxFailedLogins is the number of wrong passwords in a row that locks an account.
+	maxFailedLogins = 5
+	// lockoutDuration is how long an account stays locked after too many failures.
+	lockoutDuration = 15 * time.Minute
+
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	minPasswordLength = 8
+	// bcrypt ignores everything after the 72nd byte, so longer passwords are rejected.
+	maxPasswordLength = 72
+)
+
+// dummyPasswordHash is compared against when a username does not exist,
+// so a login for an unknown user takes as long as one with a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+// validateCredentials checks the username and password rules applied at registration.
+func validateCredentials(username, password string) error {
+	if n := utf8.RuneCountInString(username); n < minUsernameLength || n > maxUsernameLength {
+		return fmt.Errorf("username must be between %d and %d characters", minUsernameLength, maxUsernameLength)
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be between %d and %d bytes", minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
+
+// hashPassword returns the bcrypt hash of a password.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+// checkPassword reports whether password matches the bcrypt hash.
+func checkPassword(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type userCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RegisterHandler creates an account from a JSON username and password.
+func (m *Manager) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := requestContext(w, r)
+
+	var req userCredentials
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx = WithLogAttrs(ctx, slog.String("username", req.Username))
+
+	if err := validateCredentials(req.Username, req.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to hash password", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	user, err := m.users.Create(ctx, req.Username, hash)
+	if errors.Is(err, db.ErrUserExists) {
+		http.Error(w, "username already taken", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create user", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		Username string `json:"username"`
+	}
+
+	data, err := json.Marshal(response{Username: user.Username})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to marshal register response", "error", err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(data)
+}
+
+// LoginHandler checks a JSON username and password and answers with an OTP
+// that authenticates the websocket upgrade on ServeWS.
+func (m *Manager) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := requestContext(w, r)
+
+	var req userCredentials
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx = WithLogAttrs(ctx, slog.String("username", req.Username))
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	user, err := m.users.FindByUsername(ctx, req.Username)
+	if errors.Is(err, db.ErrUserNotFound) {
+		// compare anyway so unknown usernames cost the same as wrong passwords
+		checkPassword(string(dummyPasswordHash), req.Password)
+		m.metrics.logins.WithLabelValues(resultUnauthorized).Inc()
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to find user", "error", err)
+		m.metrics.logins.WithLabelValues(resultError).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if user.Locked(time.Now()) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*user.LockedUntil).Seconds())+1))
+		m.metrics.logins.WithLabelValues(resultLocked).Inc()
+		w.WriteHeader(http.StatusLocked)
+		return
+	}
+
+	match, err := checkPassword(user.PasswordHash, req.Password)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to check password", "error", err)
+		m.metrics.logins.WithLabelValues(resultError).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !match {
+		lockedUntil, err := m.users.RecordFailedLogin(ctx, user.ID, maxFailedLogins, lockoutDuration)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to record failed login", "error", err)
+		}
+		if lockedUntil != nil && lockedUntil.After(time.Now()) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*lockedUntil).Seconds())+1))
+			m.metrics.logins.WithLabelValues(resultLocked).Inc()
+			w.WriteHeader(http.StatusLocked)
+			return
+		}
+		m.metrics.logins.WithLabelValues(resultUnauthorized).Inc()
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if user.FailedAttempts > 0 || user.LockedUntil != nil {
+		if err := m.users.ResetFailedLogins(ctx, user.ID); err != nil {
+			slog.ErrorContext(ctx, "failed to reset failed logins", "error", err)
+		}
+	}
+
+	type response struct {
+		OTP      string `json:"otp"`
+		Username string `json:"username"`
+	}
+
+	otp, err := m.otps.NewOTP(ctx, Claims{UserID: user.ID, Username: user.Username})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to issue otp", "error", err)
+		m.metrics.logins.WithLabelValues(resultError).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp := response{
+		OTP:      otp.Key,
+		Username: otp.Claims.Username,
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to marshal login response", "error", err)
+		return
+	}
+	m.metrics.logins.WithLabelValues(resultSuccess).Inc()
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
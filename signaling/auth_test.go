@@ -0,0 +1,23 @@
+package signaling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthHandlersRequirePost(t *testing.T) {
+	m := newTestClient(t, nil).manager
+
+	for name, handler := range map[string]http.HandlerFunc{
+		"login":    m.LoginHandler,
+		"register": m.RegisterHandler,
+	} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/"+name, nil))
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("GET /%s = %d, want %d", name, rec.Code, http.StatusMethodNotAllowed)
+		}
+	}
+}
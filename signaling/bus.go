@@ -0,0 +1,148 @@
+package signaling
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrBusClosed is returned by a Bus that was closed.
+var ErrBusClosed = errors.New("bus is closed")
+
+// BusMessage is an event routed through a Bus.
+type BusMessage struct {
+	// Exclude is the session of a room that does not get the event, usually its sender.
+	Exclude string `json:"exclude,omitempty"`
+	Event   Event  `json:"event"`
+}
+
+// Bus routes the Manager's events to the rooms and sessions they are addressed to.
+// Every room with members on a node is a topic the node subscribes to, and so is
+// every session connected to it. The in-memory bus only reaches the subscribers of
+// its own node, the Postgres and NATS buses reach the other nodes of a cluster too.
+type Bus interface {
+	// Publish sends msg to the subscribers of topic on every node. The subscribers on
+	// this node have been called when it returns, the others are called asynchronously.
+	Publish(ctx context.Context, topic string, msg BusMessage) error
+	// Subscribe calls handler for every message published to topic until the returned
+	// unsubscribe is called. Handlers of one topic are called in publishing order.
+	// Messages published on any node after Subscribe returned reach handler.
+	Subscribe(topic string, handler func(BusMessage)) (unsubscribe func(), err error)
+	// Close stops routing messages, the bus cannot be used afterwards.
+	Close() error
+}
+
+// RoomTopic is the topic of a room. Room names are hashed since they may contain any
+// character, and Postgres limits channel names to 63 bytes.
+func RoomTopic(room string) string {
+	sum := sha256.Sum256([]byte(room))
+	return "room." + hex.EncodeToString(sum[:16])
+}
+
+// ClientTopic is the topic of a session.
+func ClientTopic(sessionID string) string {
+	return "client." + strings.ReplaceAll(sessionID, "-", "")
+}
+
+// subscribers holds the handlers subscribed to the topics of a node, each Bus delivers
+// to them both the messages published on this node and the ones it receives.
+type subscribers struct {
+	mu     sync.RWMutex
+	next   uint64
+	topics map[string]map[uint64]func(BusMessage)
+	closed bool
+}
+
+// add subscribes handler to topic. first reports whether topic had no handlers before,
+// remove reports whether it removed the topic's last one.
+func (s *subscribers) add(topic string, handler func(BusMessage)) (remove func() (last bool), first bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, false, ErrBusClosed
+	}
+	if s.topics == nil {
+		s.topics = make(map[string]map[uint64]func(BusMessage))
+	}
+
+	handlers, ok := s.topics[topic]
+	if !ok {
+		handlers = make(map[uint64]func(BusMessage))
+		s.topics[topic] = handlers
+	}
+	s.next++
+	id := s.next
+	handlers[id] = handler
+
+	var once sync.Once
+	remove = func() (last bool) {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			delete(handlers, id)
+			if len(handlers) == 0 && s.topics[topic] != nil {
+				delete(s.topics, topic)
+				last = true
+			}
+		})
+		return last
+	}
+	return remove, !ok, nil
+}
+
+// deliver calls the handlers of topic, outside the lock so they may subscribe and
+// unsubscribe.
+func (s *subscribers) deliver(topic string, msg BusMessage) {
+	s.mu.RLock()
+	handlers := make([]func(BusMessage), 0, len(s.topics[topic]))
+	for _, handler := range s.topics[topic] {
+		handlers = append(handlers, handler)
+	}
+	s.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+}
+
+// close drops every subscription and refuses new ones.
+func (s *subscribers) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.topics = nil
+}
+
+// memoryBus is the Bus of a single node, Publish calls the subscribers directly.
+type memoryBus struct {
+	subs subscribers
+}
+
+// NewMemoryBus returns the Bus a Manager uses unless it runs in a cluster.
+func NewMemoryBus() Bus {
+	return &memoryBus{}
+}
+
+func (b *memoryBus) Publish(ctx context.Context, topic string, msg BusMessage) error {
+	b.subs.deliver(topic, msg)
+	return nil
+}
+
+func (b *memoryBus) Subscribe(topic string, handler func(BusMessage)) (func(), error) {
+	remove, _, err := b.subs.add(topic, handler)
+	if err != nil {
+		return nil, err
+	}
+	return func() { remove() }, nil
+}
+
+func (b *memoryBus) Close() error {
+	b.subs.close()
+	return nil
+}
@@ -0,0 +1,121 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsBus routes messages between nodes through a NATS server, on a subject per topic.
+// The connection does not echo, a node's own messages are delivered by Publish.
+type natsBus struct {
+	conn *nats.Conn
+	subs subscribers
+
+	// mu serializes subscribing and unsubscribing, so a topic has a NATS subscription
+	// exactly while it has handlers.
+	mu            sync.Mutex
+	subscriptions map[string]*nats.Subscription
+}
+
+// NewNATSBus connects to the NATS server at url and returns a Bus that reaches the
+// nodes connected to it.
+func NewNATSBus(url, nodeID string) (Bus, error) {
+	conn, err := nats.Connect(url,
+		nats.Name(nodeID),
+		nats.NoEcho(),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				slog.Error("nats bus disconnected", "error", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			slog.Info("nats bus reconnected", "url", conn.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to nats: %w", err)
+	}
+
+	return &natsBus{
+		conn:          conn,
+		subscriptions: make(map[string]*nats.Subscription),
+	}, nil
+}
+
+func (b *natsBus) Publish(ctx context.Context, topic string, msg BusMessage) error {
+	b.subs.deliver(topic, msg)
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bus message: %v", err)
+	}
+	if err := b.conn.Publish(topic, data); err != nil {
+		return fmt.Errorf("unable to publish to %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+func (b *natsBus) Subscribe(topic string, handler func(BusMessage)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	remove, first, err := b.subs.add(topic, handler)
+	if err != nil {
+		return nil, err
+	}
+	if first {
+		// one NATS subscription per topic, its messages are handled in order on their own goroutine
+		sub, err := b.conn.Subscribe(topic, func(m *nats.Msg) {
+			var msg BusMessage
+			if err := json.Unmarshal(m.Data, &msg); err != nil {
+				slog.Error("bad bus message", "subject", m.Subject, "error", err)
+				return
+			}
+			b.subs.deliver(topic, msg)
+		})
+		if err != nil {
+			remove()
+			return nil, fmt.Errorf("unable to subscribe to %s: %w", topic, err)
+		}
+		// the server knows the subscription once the flush returns
+		if err := b.conn.FlushTimeout(dbTimeout); err != nil {
+			remove()
+			sub.Unsubscribe()
+			return nil, fmt.Errorf("unable to subscribe to %s: %w", topic, err)
+		}
+		b.subscriptions[topic] = sub
+	}
+
+	return func() { b.unsubscribe(topic, remove) }, nil
+}
+
+// unsubscribe removes a handler of topic, and the topic's NATS subscription with its
+// last handler, whichever handler subscribed first.
+func (b *natsBus) unsubscribe(topic string, remove func() (last bool)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !remove() {
+		return
+	}
+	sub, ok := b.subscriptions[topic]
+	if !ok {
+		return
+	}
+	delete(b.subscriptions, topic)
+	if err := sub.Unsubscribe(); err != nil && b.conn.IsConnected() {
+		slog.Error("failed to unsubscribe from nats", "subject", topic, "error", err)
+	}
+}
+
+func (b *natsBus) Close() error {
+	b.subs.close()
+	return b.conn.Drain()
+}
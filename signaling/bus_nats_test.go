@@ -0,0 +1,89 @@
+package signaling
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// runNATSServer starts an embedded NATS server on a random port.
+func runNATSServer(t *testing.T) string {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go srv.Start()
+	t.Cleanup(srv.Shutdown)
+
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	return srv.ClientURL()
+}
+
+func newTestNATSBus(t *testing.T, url, nodeID string) *natsBus {
+	t.Helper()
+
+	bus, err := NewNATSBus(url, nodeID)
+	if err != nil {
+		t.Fatalf("NewNATSBus: %v", err)
+	}
+	t.Cleanup(func() { bus.Close() })
+	return bus.(*natsBus)
+}
+
+func TestNATSBusResubscribeAfterFirstLeaves(t *testing.T) {
+	url := runNATSServer(t)
+	a := newTestNATSBus(t, url, "a")
+	b := newTestNATSBus(t, url, "b")
+
+	topic := RoomTopic("lobby")
+	first, err := a.Subscribe(topic, func(BusMessage) {})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	second, err := a.Subscribe(topic, func(BusMessage) {})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// the first handler leaves before the second, the subscription must go with the last
+	first()
+	second()
+	if n := a.conn.NumSubscriptions(); n != 0 {
+		t.Fatalf("%d nats subscriptions left after the last handler left", n)
+	}
+
+	received := make(chan BusMessage, 4)
+	third, err := a.Subscribe(topic, func(msg BusMessage) { received <- msg })
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer third()
+	if n := a.conn.NumSubscriptions(); n != 1 {
+		t.Fatalf("%d nats subscriptions for one topic, want 1", n)
+	}
+
+	msg := BusMessage{Event: Event{Type: EventNewMessage}}
+	if err := b.Publish(context.Background(), topic, msg); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if got.Event.Type != msg.Event.Type {
+			t.Fatalf("received %q, want %q", got.Event.Type, msg.Event.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+	}
+	select {
+	case <-received:
+		t.Fatal("message delivered twice")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
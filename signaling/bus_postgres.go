@@ -0,0 +1,193 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+const (
+	// notifyPayloadLimit is the largest message sent with NOTIFY itself, Postgres refuses
+	// payloads of 8000 bytes and more. Larger messages, typically offers, are stored in
+	// cluster_payloads and the notification only carries their ID.
+	notifyPayloadLimit = 7900
+	// payloadRetention is how long stored payloads are kept for the nodes to read them.
+	payloadRetention = time.Minute
+)
+
+// postgresBus routes messages between nodes with LISTEN and NOTIFY, on a channel per
+// topic. It listens on a dedicated connection and notifies through the pool.
+// Every published message is sent with NOTIFY, whether another node listens to its
+// channel or not, Postgres discards a notification nobody listens to.
+type postgresBus struct {
+	node     string
+	payloads *db.ClusterRepository
+	listener *db.Listener
+	subs     subscribers
+
+	// mu serializes subscribing and unsubscribing, so a topic's channel is listened to
+	// exactly while it has handlers.
+	mu sync.Mutex
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// postgresMessage is a BusMessage as it is sent with NOTIFY.
+type postgresMessage struct {
+	// Node is the sender, nodes skip their own messages since Publish delivered them
+	// to the local subscribers already.
+	Node  string     `json:"node,omitempty"`
+	Topic string     `json:"topic,omitempty"`
+	Msg   BusMessage `json:"msg"`
+	// Ref is set instead of the other fields for a message too large for NOTIFY,
+	// it is the ID of the cluster_payloads row holding it.
+	Ref int64 `json:"ref,omitempty"`
+}
+
+// NewPostgresBus returns a Bus that reaches the nodes sharing pool's database. nodeID
+// must be unique among them.
+func NewPostgresBus(pool *pgxpool.Pool, nodeID string) Bus {
+	b := &postgresBus{
+		node:     nodeID,
+		payloads: db.NewClusterRepository(pool),
+	}
+	b.listener = db.NewListener(pool.Config().ConnConfig.Copy(), b.receive)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+
+	b.wg.Add(2)
+	go func() {
+		defer b.wg.Done()
+		b.listener.Run(ctx)
+	}()
+	go func() {
+		defer b.wg.Done()
+		b.deletePayloads(ctx)
+	}()
+
+	return b
+}
+
+// postgresChannel is the channel of a topic, the dot is not allowed in an identifier.
+func postgresChannel(topic string) string {
+	return strings.ReplaceAll(topic, ".", "_")
+}
+
+func (b *postgresBus) Publish(ctx context.Context, topic string, msg BusMessage) error {
+	b.subs.deliver(topic, msg)
+
+	data, err := json.Marshal(postgresMessage{Node: b.node, Topic: topic, Msg: msg})
+	if err != nil {
+		return fmt.Errorf("failed to marshal bus message: %v", err)
+	}
+
+	if len(data) > notifyPayloadLimit {
+		id, err := b.payloads.SavePayload(ctx, string(data))
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(postgresMessage{Ref: id}); err != nil {
+			return fmt.Errorf("failed to marshal bus message: %v", err)
+		}
+	}
+
+	return b.payloads.Notify(ctx, postgresChannel(topic), string(data))
+}
+
+func (b *postgresBus) Subscribe(topic string, handler func(BusMessage)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	remove, first, err := b.subs.add(topic, handler)
+	if err != nil {
+		return nil, err
+	}
+	if first {
+		// the topic's messages published once Subscribe returned are received
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		err := b.listener.Listen(ctx, postgresChannel(topic))
+		cancel()
+		if err != nil {
+			if remove() {
+				b.listener.Unlisten(postgresChannel(topic))
+			}
+			return nil, fmt.Errorf("unable to listen to %s: %w", topic, err)
+		}
+	}
+
+	return func() { b.unsubscribe(topic, remove) }, nil
+}
+
+// unsubscribe removes a handler of topic, and stops listening to the topic's channel
+// with its last handler.
+func (b *postgresBus) unsubscribe(topic string, remove func() (last bool)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if remove() {
+		b.listener.Unlisten(postgresChannel(topic))
+	}
+}
+
+func (b *postgresBus) Close() error {
+	b.subs.close()
+	b.cancel()
+	b.wg.Wait()
+	return nil
+}
+
+// receive delivers a message of another node to the subscribers of its topic.
+// It runs on the listener's goroutine.
+func (b *postgresBus) receive(channel, payload string) {
+	var msg postgresMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		slog.Error("bad bus message", "channel", channel, "error", err)
+		return
+	}
+
+	if msg.Ref != 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		stored, err := b.payloads.LoadPayload(ctx, msg.Ref)
+		cancel()
+		if err != nil {
+			slog.Error("failed to load bus message", "channel", channel, "ref", msg.Ref, "error", err)
+			return
+		}
+		if err := json.Unmarshal([]byte(stored), &msg); err != nil {
+			slog.Error("bad bus message", "channel", channel, "ref", msg.Ref, "error", err)
+			return
+		}
+	}
+
+	if msg.Node == b.node {
+		return
+	}
+	b.subs.deliver(msg.Topic, msg.Msg)
+}
+
+// deletePayloads deletes the stored payloads every node has read, until ctx is cancelled.
+func (b *postgresBus) deletePayloads(ctx context.Context) {
+	ticker := time.NewTicker(payloadRetention)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := b.payloads.DeletePayloadsBefore(ctx, time.Now().Add(-payloadRetention)); err != nil && ctx.Err() == nil {
+			slog.Error("failed to delete bus payloads", "error", err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package signaling
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPostgresBusConcurrentSubscribers(t *testing.T) {
+	pool := testPool(t)
+	a := NewPostgresBus(pool, "node-"+uuid.NewString())
+	t.Cleanup(func() { a.Close() })
+	b := NewPostgresBus(pool, "node-"+uuid.NewString())
+	t.Cleanup(func() { b.Close() })
+
+	// members join and leave the room at once, the last one to leave unsubscribes
+	// while the next one to join subscribes
+	topic := RoomTopic("room-" + uuid.NewString())
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unsubscribe, err := a.Subscribe(topic, func(BusMessage) {})
+			if err != nil {
+				t.Errorf("Subscribe: %v", err)
+				return
+			}
+			unsubscribe()
+		}()
+	}
+	wg.Wait()
+
+	received := make(chan BusMessage, 1)
+	unsubscribe, err := a.Subscribe(topic, func(msg BusMessage) { received <- msg })
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer unsubscribe()
+
+	if err := b.Publish(context.Background(), topic, BusMessage{Event: Event{Type: EventNewMessage}}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the channel is not listened to while it has a subscriber")
+	}
+}
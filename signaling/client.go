@@ -0,0 +1,315 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type ClientList map[*Client]bool
+
+// closeSessionExpired is the close code a resumed websocket is closed with when its
+// session token is unknown or expired, the client has to log in again.
+const closeSessionExpired = 4001
+
+// ErrClientClosed is returned when sending to a client that is closing.
+var ErrClientClosed = errors.New("client is closed")
+
+// ErrEventDropped is returned when the client's full egress dropped the event sent to it.
+var ErrEventDropped = errors.New("event dropped, the client's egress is full")
+
+// closeSlowClient is the close code of a websocket whose client stopped reading the
+// events sent to it.
+const closeSlowClient = 4002
+
+type Client struct {
+	// connection is the client's current websocket, it is replaced when the session
+	// is resumed. It is guarded by the manager's lock.
+	connection *websocket.Conn
+	manager    *Manager
+	// ID identifies this websocket session, it is what signaling events address
+	ID string
+	// UserID and Username identify the account the session was authenticated as
+	UserID   int64
+	Username string
+
+	// room is the room the client is in, nil until it joins one.
+	// It is guarded by the manager's lock, read it through Room.
+	room *Room
+
+	// egress queues the outgoing events for the write goroutine, the only writer of
+	// the websocket, which also closes it.
+	egress *egressQueue
+	// writing is held by the write goroutine. The one of a resumed websocket waits for
+	// the one of the dropped websocket to return, so events are popped in order by one.
+	writing sync.Mutex
+	// ctx lives as long as the session, across resumes, close cancels it.
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	closeOnce sync.Once
+	// slow is set once the client is being disconnected for not reading its events.
+	slow atomic.Bool
+
+	// token resumes the session after its websocket dropped, it changes on every resume.
+	// stop ends the write goroutine of the current websocket, expire ends the session
+	// once it was detached for too long. All three are guarded by the manager's lock.
+	token  string
+	stop   chan struct{}
+	expire *time.Timer
+	// unsubscribe ends the session's subscription to its bus topic, it is guarded by
+	// the manager's lock too.
+	unsubscribe func()
+	// detached is set while the session has no websocket and waits to be resumed,
+	// events sent to it meanwhile stay queued on egress.
+	detached atomic.Bool
+	// pingSentAt is when the last ping was written in unix nanoseconds, zero once its
+	// pong arrived, the pong handler measures the round trip with it.
+	pingSentAt atomic.Int64
+}
+
+func NewClient(conn *websocket.Conn, manager *Manager, claims Claims, sessionID string) *Client {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	return &Client{
+		connection: conn,
+		manager:    manager,
+		ID:         sessionID,
+		UserID:     claims.UserID,
+		Username:   claims.Username,
+		egress:     newEgressQueue(manager.server.EGRESS_BUFFER, manager.server.EGRESS_DROPPABLE),
+		ctx:        ctx,
+		cancel:     cancel,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Context is cancelled with ErrClientClosed as its cause once the client is closing.
+// The websocket dropping does not cancel it while the session can still be resumed.
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// close ends the session: the write goroutine sends the events queued so far, then a
+// close frame with code and reason, and closes the websocket. Events sent afterwards
+// are refused and the client's context is cancelled. Only the first call has an effect,
+// removeClient calls it for every client it removes.
+func (c *Client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.egress.close(websocket.FormatCloseMessage(code, reason))
+		c.cancel(ErrClientClosed)
+	})
+}
+
+// serve starts the read and write goroutines of conn, the client's current websocket.
+// ctx carries the correlation ID of the upgrade request and the client's attributes
+// from logContext, every line logged for the websocket and every query made while
+// handling its events carries them.
+func (c *Client) serve(ctx context.Context, conn *websocket.Conn, stop chan struct{}) {
+	go c.readMessages(ctx, conn)
+	go c.writeMessages(ctx, conn, stop)
+}
+
+func (c *Client) readMessages(ctx context.Context, conn *websocket.Conn) {
+	// a websocket that was closed on purpose ends the session, any other failure
+	// detaches it so it can be resumed
+	resumable := true
+	defer func() {
+		// cleanp connection
+		c.manager.dropClient(c, conn, resumable)
+	}()
+
+	// the handlers are cancelled with the client, the values of ctx are kept
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	defer context.AfterFunc(c.ctx, func() { cancel(context.Cause(c.ctx)) })()
+
+	pongWait := c.manager.server.PONG_WAIT
+	if err := conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
+		slog.ErrorContext(ctx, "failed to set read deadline", "error", err)
+		return
+	}
+
+	conn.SetReadLimit(c.manager.server.MAX_MESSAGE_SIZE)
+
+	conn.SetPongHandler(func(string) error {
+		if sentAt := c.pingSentAt.Swap(0); sentAt != 0 {
+			rtt := time.Since(time.Unix(0, sentAt))
+			c.manager.metrics.pingRTT.Observe(rtt.Seconds())
+			slog.DebugContext(ctx, "pong", "rtt", rtt)
+		}
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, payload, err := conn.ReadMessage()
+
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				slog.WarnContext(ctx, "error reading message", "error", err)
+			}
+			resumable = !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) &&
+				!errors.Is(err, websocket.ErrReadLimit)
+			break
+		}
+
+		var request Event
+
+		if err := json.Unmarshal(payload, &request); err != nil {
+			c.replyError(ctx, Event{}, NewHandlerError(ErrCodeBadRequest, fmt.Sprintf("malformed event: %v", err)))
+			continue
+		}
+
+		eventCtx := c.eventContext(ctx, request.Type)
+		if err := c.manager.routeEvent(eventCtx, request, c); err != nil {
+			c.replyError(eventCtx, request, err)
+		}
+	}
+}
+
+func (c *Client) writeMessages(ctx context.Context, conn *websocket.Conn, stop <-chan struct{}) {
+	c.writing.Lock()
+	defer c.writing.Unlock()
+
+	ticker := time.NewTicker(c.manager.server.PING_INTERVAL)
+	defer ticker.Stop()
+	// the websocket is closed here only, so it is never closed under a write
+	defer conn.Close()
+
+	for {
+		select {
+		case <-stop:
+			// the websocket was dropped, the queued events wait for a resume
+			return
+		case <-c.egress.ready:
+			select {
+			case <-stop:
+				// dropped while woken up, leave the wake up to the writer of a resume
+				c.egress.signal()
+				return
+			default:
+			}
+
+			message, ok := c.egress.pop()
+			if !ok {
+				frame, closed := c.egress.closeFrame()
+				if !closed {
+					continue
+				}
+
+				// everything queued before the close is sent, e.g. server_shutdown
+				if err := conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(writeWait)); err != nil {
+					slog.DebugContext(ctx, "failed to write close frame", "error", err)
+				}
+				c.manager.dropClient(c, conn, false)
+				return
+			}
+
+			if err := writeEvent(ctx, conn, message); err != nil {
+				slog.WarnContext(ctx, "failed to write event", "event", message.Type, "error", err)
+				// the event is sent again if the session is resumed
+				c.egress.requeue(message)
+				c.manager.dropClient(c, conn, true)
+				return
+			}
+			c.manager.metrics.eventsSent.WithLabelValues(message.Type).Inc()
+		case <-ticker.C:
+			slog.DebugContext(ctx, "ping")
+			// send ping to the client
+			c.pingSentAt.Store(time.Now().UnixNano())
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				slog.WarnContext(ctx, "failed to write ping", "error", err)
+				c.manager.dropClient(c, conn, true)
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(ctx context.Context, conn *websocket.Conn, message Event) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	slog.DebugContext(ctx, "event sent", "event", message.Type)
+	return nil
+}
+
+// Manager returns the manager the client is registered with.
+func (c *Client) Manager() *Manager {
+	return c.manager
+}
+
+// Peer returns the identity other room members use to address this client.
+func (c *Client) Peer() Peer {
+	return Peer{
+		SessionID: c.ID,
+		UserID:    c.UserID,
+		Username:  c.Username,
+	}
+}
+
+// Room returns the room the client is in, or nil if it has not joined one.
+func (c *Client) Room() *Room {
+	c.manager.RLock()
+	defer c.manager.RUnlock()
+
+	return c.room
+}
+
+// EgressStats returns the state of the client's queue of outgoing events.
+func (c *Client) EgressStats() EgressStats {
+	return c.egress.stats()
+}
+
+// Send queues event on the client's egress without waiting for it to be written, see
+// Deliver. It fails with ctx's error if ctx is done, with ErrClientClosed once the
+// client is closing and with ErrEventDropped if the client's full egress dropped it.
+func (c *Client) Send(ctx context.Context, event Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.deliver(event)
+}
+
+// RelayToPeer sends a signaling payload to the member of room whose session ID is to.
+// Delivery happens on the sender's read goroutine so a peer sees the sender's offer,
+// answer and candidates in the order they were sent. In cluster mode the member may
+// be connected to another node. If there is no such member it fails with
+// ErrCodeUnknownTarget.
+func (c *Client) RelayToPeer(ctx context.Context, room *Room, to, eventType string, payload any) error {
+	found := false
+	if target := room.Client(to); target != nil {
+		found = target != c
+	} else if c.manager.cluster != nil {
+		for _, member := range c.manager.members(ctx, room) {
+			if member.Peer.SessionID == to && member.client == nil {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return NewHandlerError(ErrCodeUnknownTarget, fmt.Sprintf("no peer %q in room %s", to, room.Name))
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %v", eventType, err)
+	}
+
+	return c.manager.sendTo(ctx, to, Event{Type: eventType, Payload: data})
+}
@@ -0,0 +1,108 @@
+package signaling
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+)
+
+// testWebsocket returns the server side of a websocket connected to a test client.
+func testWebsocket(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	conn := <-conns
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func newTestClient(t *testing.T, conn *websocket.Conn) *Client {
+	t.Helper()
+
+	m := NewManager(nil, nil, config.ICEConfig{}, config.RecordingConfig{}, config.ServerConfig{
+		EGRESS_BUFFER:       64,
+		EGRESS_FULL_TIMEOUT: time.Minute,
+		PING_INTERVAL:       time.Hour,
+	})
+	return NewClient(conn, m, Claims{UserID: 7, Username: "alice"}, "session")
+}
+
+// runWriter runs writeMessages and fails the test unless it returns in time.
+func runWriter(t *testing.T, c *Client, conn *websocket.Conn, stop chan struct{}) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.writeMessages(context.Background(), conn, stop)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writeMessages did not return")
+	}
+}
+
+func TestWriteMessagesStoppedKeepsEvents(t *testing.T) {
+	conn := testWebsocket(t)
+	c := newTestClient(t, conn)
+
+	if err := c.deliver(Event{Type: EventNewMessage}); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	// the websocket was dropped before the writer woke up for the event
+	stop := make(chan struct{})
+	close(stop)
+	runWriter(t, c, conn, stop)
+
+	if stats := c.EgressStats(); stats.Queued != 1 {
+		t.Fatalf("%d events queued after the writer stopped, want 1", stats.Queued)
+	}
+}
+
+func TestWriteMessagesRequeuesFailedWrite(t *testing.T) {
+	conn := testWebsocket(t)
+	c := newTestClient(t, conn)
+
+	for _, eventType := range []string{EventOffer, EventAnswer} {
+		if err := c.deliver(Event{Type: eventType}); err != nil {
+			t.Fatalf("deliver: %v", err)
+		}
+	}
+
+	// every write fails on the closed connection
+	conn.NetConn().Close()
+	runWriter(t, c, conn, make(chan struct{}))
+
+	first, ok := c.egress.pop()
+	if !ok || first.Type != EventOffer {
+		t.Fatalf("first queued event = %q, want the %q that failed to be written", first.Type, EventOffer)
+	}
+	if stats := c.EgressStats(); stats.Queued != 1 {
+		t.Fatalf("%d events queued behind it, want 1", stats.Queued)
+	}
+}
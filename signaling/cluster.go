@@ -0,0 +1,157 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+// Cluster lets the Managers of several server instances share rooms. Every node stores
+// the room members connected to it in the room_members table, so each node can list a
+// room's members on all nodes, and the Manager's events reach the other nodes through
+// a Postgres or NATS Bus.
+//
+// Only signaling and chat cross nodes. An SFU room's media, its recording and the
+// user_ready handshake stay with the members on the node they are connected to, and
+// a room's capacity is enforced per node.
+type Cluster struct {
+	// NodeID identifies this instance in cluster_nodes and room_members.
+	NodeID string
+
+	cfg   config.ClusterConfig
+	nodes *db.ClusterRepository
+	bus   Bus
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// StartCluster registers the node, deletes the room members it left behind if it ran
+// before with the same ID, makes manager list room members and route its events through
+// the cluster and starts sending heartbeats. It must be called before manager serves
+// websockets, and the cluster closed before the pool.
+func StartCluster(ctx context.Context, pool *pgxpool.Pool, cfg config.ClusterConfig, manager *Manager) (*Cluster, error) {
+	if cfg.NODE_ID == "" {
+		return nil, errors.New("cluster node id is required")
+	}
+	if cfg.HEARTBEAT_INTERVAL <= 0 || cfg.NODE_TTL <= cfg.HEARTBEAT_INTERVAL {
+		return nil, fmt.Errorf("cluster node ttl %s must be longer than the heartbeat interval %s",
+			cfg.NODE_TTL, cfg.HEARTBEAT_INTERVAL)
+	}
+
+	c := &Cluster{
+		NodeID: cfg.NODE_ID,
+		cfg:    cfg,
+		nodes:  db.NewClusterRepository(pool),
+	}
+
+	if err := c.nodes.Heartbeat(ctx, c.NodeID); err != nil {
+		return nil, err
+	}
+	// the node may have stopped without Close, its heartbeat is fresh again so the
+	// other nodes would keep listing its members
+	if n, err := c.nodes.RemoveNodeMembers(ctx, c.NodeID); err != nil {
+		return nil, err
+	} else if n > 0 {
+		slog.Warn("deleted room members left by a previous run", "node", c.NodeID, "count", n)
+	}
+
+	switch cfg.BUS {
+	case config.ClusterBusPostgres:
+		c.bus = NewPostgresBus(pool, c.NodeID)
+	case config.ClusterBusNATS:
+		bus, err := NewNATSBus(cfg.NATS_URL, c.NodeID)
+		if err != nil {
+			return nil, err
+		}
+		c.bus = bus
+	default:
+		return nil, fmt.Errorf("unknown cluster bus %q", cfg.BUS)
+	}
+
+	manager.Lock()
+	manager.cluster = c
+	manager.bus = c.bus
+	manager.Unlock()
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.heartbeat(runCtx)
+	}()
+
+	slog.Info("joined cluster", "node", c.NodeID, "bus", cfg.BUS)
+	return c, nil
+}
+
+// Close stops the bus and the heartbeats and deletes the node with its room members,
+// the other nodes stop listing them. Shut the manager down first.
+func (c *Cluster) Close() error {
+	c.cancel()
+	c.wg.Wait()
+
+	if err := c.bus.Close(); err != nil {
+		slog.Error("failed to close cluster bus", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return c.nodes.RemoveNode(ctx, c.NodeID)
+}
+
+// heartbeat refreshes the node's row and deletes the nodes that stopped refreshing
+// theirs, together with their members, until ctx is cancelled.
+func (c *Cluster) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.HEARTBEAT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		if err := c.nodes.Heartbeat(ctx, c.NodeID); err != nil && ctx.Err() == nil {
+			slog.Error("failed to send cluster heartbeat", "node", c.NodeID, "error", err)
+		}
+		if n, err := c.nodes.DeleteStaleNodes(ctx, now.Add(-c.cfg.NODE_TTL)); err != nil && ctx.Err() == nil {
+			slog.Error("failed to delete stale cluster nodes", "error", err)
+		} else if n > 0 {
+			slog.Warn("deleted stale cluster nodes", "count", n)
+		}
+	}
+}
+
+// join stores that client joined room on this node and returns its join sequence number.
+func (c *Cluster) join(ctx context.Context, client *Client, room string) (int64, error) {
+	return c.nodes.AddMember(ctx, db.RoomMember{
+		SessionID: client.ID,
+		NodeID:    c.NodeID,
+		Room:      room,
+		UserID:    client.UserID,
+		Username:  client.Username,
+	})
+}
+
+// leave deletes client from the members of room.
+func (c *Cluster) leave(ctx context.Context, client *Client, room string) error {
+	return c.nodes.RemoveMember(ctx, client.ID, room)
+}
+
+// members returns the members of room on every node in the order they joined.
+func (c *Cluster) members(ctx context.Context, room string) ([]db.RoomMember, error) {
+	return c.nodes.Members(ctx, room)
+}
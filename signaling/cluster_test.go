@@ -0,0 +1,111 @@
+package signaling
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+// startTestNode returns a Manager in the cluster of pool's database as node nodeID.
+func startTestNode(t *testing.T, pool *pgxpool.Pool, nodeID string) *Manager {
+	t.Helper()
+
+	m := NewManager(pool, nil, config.ICEConfig{}, config.RecordingConfig{}, config.ServerConfig{
+		EGRESS_BUFFER:       64,
+		EGRESS_FULL_TIMEOUT: time.Minute,
+	})
+	cluster, err := StartCluster(context.Background(), pool, config.ClusterConfig{
+		ENABLED:            true,
+		BUS:                config.ClusterBusPostgres,
+		NODE_ID:            nodeID,
+		HEARTBEAT_INTERVAL: time.Second,
+		NODE_TTL:           5 * time.Second,
+	}, m)
+	if err != nil {
+		t.Fatalf("StartCluster %s: %v", nodeID, err)
+	}
+	t.Cleanup(func() { cluster.Close() })
+
+	return m
+}
+
+// joinTestClient connects a client to m and joins it to room.
+func joinTestClient(t *testing.T, m *Manager, username, room string) *Client {
+	t.Helper()
+
+	c := NewClient(nil, m, Claims{UserID: 1, Username: username}, uuid.NewString())
+	m.addClient(c)
+	if _, err := m.JoinRoom(c, room, RoomOptions{}); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	return c
+}
+
+// waitEvent pops c's queued events until one of eventType arrives.
+func waitEvent(t *testing.T, c *Client, eventType string) Event {
+	t.Helper()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if event, ok := c.egress.pop(); ok {
+			if event.Type == eventType {
+				return event
+			}
+			continue
+		}
+
+		select {
+		case <-c.egress.ready:
+		case <-deadline:
+			t.Fatalf("%s did not get %s", c.Username, eventType)
+		}
+	}
+}
+
+func TestClusterTwoManagers(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+	room := "room-" + uuid.NewString()
+
+	// a member node a stored before it stopped without closing its cluster
+	nodeA := "node-" + uuid.NewString()
+	nodes := db.NewClusterRepository(pool)
+	if err := nodes.Heartbeat(ctx, nodeA); err != nil {
+		t.Fatalf("Heartbeat: %v", err)
+	}
+	if _, err := nodes.AddMember(ctx, db.RoomMember{
+		SessionID: uuid.NewString(), NodeID: nodeA, Room: room, UserID: 1, Username: "ghost",
+	}); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+
+	a := startTestNode(t, pool, nodeA)
+	b := startTestNode(t, pool, "node-"+uuid.NewString())
+
+	// JoinRoom returns once the node listens to the room's channel, the events below
+	// are published after both did
+	alice := joinTestClient(t, a, "alice", room)
+	bob := joinTestClient(t, b, "bob", room)
+
+	for _, c := range []*Client{alice, bob} {
+		members := c.manager.members(ctx, c.Room())
+		if len(members) != 2 || members[0].Peer.SessionID != alice.ID || members[1].Peer.SessionID != bob.ID {
+			t.Fatalf("node %s lists members %+v, want alice then bob", c.manager.cluster.NodeID, members)
+		}
+	}
+
+	// a room event of one node reaches the members on the other
+	a.broadcast(ctx, alice.Room(), alice, Event{Type: EventNewMessage})
+	waitEvent(t, bob, EventNewMessage)
+
+	// so does an event addressed to a session
+	if err := b.sendTo(ctx, alice.ID, Event{Type: EventOffer}); err != nil {
+		t.Fatalf("sendTo: %v", err)
+	}
+	waitEvent(t, alice, EventOffer)
+}
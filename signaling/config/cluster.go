@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Cluster buses carry the events between the instances of a cluster.
+const (
+	ClusterBusPostgres = "postgres"
+	ClusterBusNATS     = "nats"
+)
+
+// ClusterConfig is the configuration of cluster mode, where several server instances
+// share rooms. The room members are stored in Postgres, the events are routed through
+// the configured bus.
+type ClusterConfig struct {
+	// ENABLED turns cluster mode on.
+	ENABLED bool
+	// BUS is ClusterBusPostgres, which uses LISTEN and NOTIFY, or ClusterBusNATS.
+	BUS string
+	// NATS_URL is the NATS server of ClusterBusNATS.
+	NATS_URL string
+	// NODE_ID names this instance, it must be unique in the cluster. The default is
+	// the host name with a random suffix, so a restarted instance is a new node.
+	NODE_ID string
+	// HEARTBEAT_INTERVAL is how often the node refreshes its row in cluster_nodes.
+	HEARTBEAT_INTERVAL time.Duration
+	// NODE_TTL is how long a node may miss heartbeats before the other nodes delete
+	// it and its room members, it should be several heartbeat intervals.
+	NODE_TTL time.Duration
+}
+
+const (
+	defaultHeartbeatInterval = 5 * time.Second
+	defaultNodeTTL           = 30 * time.Second
+	defaultNATSURL           = "nats://127.0.0.1:4222"
+)
+
+// LoadClusterConfig loads the cluster configuration from the environment,
+// applying defaults if not set. It returns an error if a duration does not parse.
+func LoadClusterConfig() (ClusterConfig, error) {
+	heartbeatInterval, heartbeatErr := getDurationEnv("CLUSTER_HEARTBEAT_INTERVAL", defaultHeartbeatInterval)
+	nodeTTL, ttlErr := getDurationEnv("CLUSTER_NODE_TTL", defaultNodeTTL)
+	if err := errors.Join(heartbeatErr, ttlErr); err != nil {
+		return ClusterConfig{}, fmt.Errorf("invalid cluster config: %w", err)
+	}
+
+	return ClusterConfig{
+		ENABLED:            getBoolEnv("CLUSTER_ENABLED", false),
+		BUS:                strings.ToLower(getEnvWithDefault("CLUSTER_BUS", ClusterBusPostgres)),
+		NATS_URL:           getEnvWithDefault("CLUSTER_NATS_URL", defaultNATSURL),
+		NODE_ID:            getEnvWithDefault("CLUSTER_NODE_ID", defaultNodeID()),
+		HEARTBEAT_INTERVAL: heartbeatInterval,
+		NODE_TTL:           nodeTTL,
+	}, nil
+}
+
+func defaultNodeID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "node"
+	}
+	return host + "-" + uuid.NewString()[:8]
+}
@@ -2,17 +2,19 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/tracelog"
 
-	pgxslog "github.com/zenk41/PeerChat/internal"
+	pgxslog "github.com/zenk41/learn-webrtc/signaling/internal"
 )
 
 // PostgresConfig is the represenstation of a configuration that used for postgresql with pgxpool.
@@ -54,15 +56,19 @@ func getEnvWithDefault(key, defaultValue string) string {
 }
 
 // getDurationEnv retrieves a duration from environment variable with a fallback.
-// It returns duration of the env if the key has value.
-func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
-	if str := os.Getenv(key); key != "" {
-		if duration, err := time.ParseDuration(str); err == nil {
-			return duration
-		}
+// It returns duration of the env if the key has value, or an error naming the key
+// if the value does not parse.
+func getDurationEnv(key string, defaultValue time.Duration) (time.Duration, error) {
+	str := os.Getenv(key)
+	if str == "" {
+		return defaultValue, nil
+	}
 
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
 	}
-	return defaultValue
+	return duration, nil
 }
 
 // getInt32Env retrieves an int32 from environment variable with a fallback.
@@ -76,9 +82,56 @@ func getInt32Env(key string, defaultValue int32) int32 {
 	return defaultValue
 }
 
+// getIntEnv retrieves an int from environment variable with a fallback.
+// It returns int value of the env if the key has value.
+func getIntEnv(key string, defaultValue int) int {
+	if str := os.Getenv(key); str != "" {
+		if val, err := strconv.Atoi(str); err == nil {
+			return val
+		}
+	}
+	return defaultValue
+}
+
+// getBoolEnv retrieves a bool from environment variable with a fallback.
+// It returns bool value of the env if the key has value.
+func getBoolEnv(key string, defaultValue bool) bool {
+	if str := os.Getenv(key); str != "" {
+		if val, err := strconv.ParseBool(str); err == nil {
+			return val
+		}
+	}
+	return defaultValue
+}
+
+// getListEnv retrieves a comma separated list from environment variable with a fallback.
+// It returns the trimmed, non-empty items of the env or of the default.
+func getListEnv(key, defaultValue string) []string {
+	return splitList(getEnvWithDefault(key, defaultValue))
+}
+
+// splitList returns the trimmed, non-empty items of a comma separated list.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // loadPostgresConfig loads and returns configuration as a PostgresConfig struct.
 // It retrieces values from the environtment variables, applying defaults if not set.
-func loadPostgresConfig() PostgresConfig {
+// It returns an error if a duration does not parse.
+func loadPostgresConfig() (PostgresConfig, error) {
+	maxConnLifetime, lifetimeErr := getDurationEnv("PSQL_MAX_CONN_LIFETIME", defaultMaxConnLifetime)
+	maxConnIdleTime, idleErr := getDurationEnv("PSQL_MAX_CONN_IDLE_TIME", defaultMaxConnIdleTime)
+	connectTimeout, timeoutErr := getDurationEnv("PSQL_CONNECT_TIMEOUT", defaultConnectTimeout)
+	if err := errors.Join(lifetimeErr, idleErr, timeoutErr); err != nil {
+		return PostgresConfig{}, fmt.Errorf("invalid postgres config: %w", err)
+	}
+
 	return PostgresConfig{
 		USERNAME:           getEnvWithDefault("PSQL_USER", "postgres"),
 		PASSWORD:           getEnvWithDefault("PSQL_PASS", ""),
@@ -89,18 +142,21 @@ func loadPostgresConfig() PostgresConfig {
 		SSL_MODE:           getEnvWithDefault("PSQL_SSL_MODE", defaultSSLMode),
 		MAX_CONNS:          getInt32Env("PSQL_MAX_CONNS", defaultMaxConns),
 		MIN_CONNS:          getInt32Env("PSQL_MIN_CONNS", defaultMinConns),
-		MAX_CONN_LIFETIME:  getDurationEnv("PSQL_MAX_CONN_LIFETIME", defaultMaxConnLifetime),
-		MAX_CONN_IDLE_TIME: getDurationEnv("PSQL_MAX_CONN_IDLE_TIME", defaultMaxConnIdleTime),
-		CONNECT_TIMEOUT:    getDurationEnv("PSQL_CONNECT_TIMEOUT", defaultConnectTimeout),
-	}
+		MAX_CONN_LIFETIME:  maxConnLifetime,
+		MAX_CONN_IDLE_TIME: maxConnIdleTime,
+		CONNECT_TIMEOUT:    connectTimeout,
+	}, nil
 }
 
 // PSQLConfig returns a pointer to a pgxpool.Config for initializing a PostgreSQL connection pool.
 // It builds the configuration using environment variables and applies custom pool settings.
 // Lifecycle hooks for connection acquisition, release, and closure are also configured.
-// Logs errors and exits the application if configuration parsing fails.
-func PSQLConfig() *pgxpool.Config {
-	psqlConfig := loadPostgresConfig()
+// It returns an error if a setting or the resulting connection string does not parse.
+func PSQLConfig() (*pgxpool.Config, error) {
+	psqlConfig, err := loadPostgresConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	// Build connection string with all necessary parameters
 	dsn := fmt.Sprintf(
@@ -116,11 +172,9 @@ func PSQLConfig() *pgxpool.Config {
 
 	dbConfig, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
-		slog.Error("failed to create config", "error", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to create postgres config: %w", err)
 	}
 
-
 	// Apply configuration
 	dbConfig.MaxConns = psqlConfig.MAX_CONNS
 	dbConfig.MinConns = psqlConfig.MIN_CONNS
@@ -130,7 +184,7 @@ func PSQLConfig() *pgxpool.Config {
 	dbConfig.ConnConfig.ConnectTimeout = psqlConfig.CONNECT_TIMEOUT
 
 	dbConfig.ConnConfig.Tracer = &tracelog.TraceLog{
-		Logger: pgxslog.NewLogger(slog.Default(), pgxslog.GetShouldOmitArgs()),
+		Logger:   pgxslog.NewLogger(slog.Default(), pgxslog.GetShouldOmitArgs()),
 		LogLevel: pgxslog.GetDatabaseLogLevel(),
 	}
 
@@ -149,5 +203,5 @@ func PSQLConfig() *pgxpool.Config {
 		return true
 	}
 
-	return dbConfig
+	return dbConfig, nil
 }
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ICEConfig is the ICE server list handed to clients in the ice_config event.
+type ICEConfig struct {
+	// STUN_URLS are sent as they are, they need no credentials.
+	STUN_URLS []string
+	// TURN_URLS are sent with TURN REST credentials signed with TURN_SECRET,
+	// the same secret the TURN server verifies them with.
+	TURN_URLS   []string
+	TURN_SECRET string
+	// CREDENTIAL_TTL is how long minted TURN credentials stay valid.
+	CREDENTIAL_TTL time.Duration
+}
+
+const (
+	defaultSTUNURLs          = "stun:stun1.l.google.com:19302,stun:stun2.l.google.com:19302"
+	defaultTURNCredentialTTL = time.Hour
+)
+
+// LoadICEConfig loads the ICE server configuration from the environment,
+// applying defaults if not set. It returns an error if the credential TTL does not parse.
+func LoadICEConfig() (ICEConfig, error) {
+	credentialTTL, err := getDurationEnv("ICE_CREDENTIAL_TTL", defaultTURNCredentialTTL)
+	if err != nil {
+		return ICEConfig{}, fmt.Errorf("invalid ice config: %w", err)
+	}
+
+	return ICEConfig{
+		STUN_URLS:      getListEnv("ICE_STUN_URLS", defaultSTUNURLs),
+		TURN_URLS:      getListEnv("ICE_TURN_URLS", ""),
+		TURN_SECRET:    getEnvWithDefault("TURN_SECRET", ""),
+		CREDENTIAL_TTL: credentialTTL,
+	}, nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// Log formats select the slog handler the server logs through.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogConfig is the configuration of the server's structured logs.
+type LogConfig struct {
+	// FORMAT is LogFormatText or LogFormatJSON.
+	FORMAT string
+	// LEVEL is the lowest level that is logged.
+	LEVEL slog.Level
+}
+
+// LoadLogConfig loads the log configuration from LOG_FORMAT and LOG_LEVEL. The level
+// is a slog level name like debug, info, warn or error, optionally with an offset
+// such as debug-4. Unknown values fall back to text and info.
+func LoadLogConfig() LogConfig {
+	format := strings.ToLower(getEnvWithDefault("LOG_FORMAT", LogFormatText))
+	if format != LogFormatJSON {
+		format = LogFormatText
+	}
+
+	level := slog.LevelInfo
+	if err := level.UnmarshalText([]byte(getEnvWithDefault("LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	return LogConfig{
+		FORMAT: format,
+		LEVEL:  level,
+	}
+}
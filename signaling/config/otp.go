@@ -0,0 +1,33 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// OTPConfig is the configuration of the one-time password store used to authenticate websockets.
+type OTPConfig struct {
+	// STORE selects the backend, either "memory" or "postgres".
+	STORE string
+	// TTL is how long an issued one-time password can be redeemed.
+	TTL time.Duration
+}
+
+const (
+	defaultOTPStore = "memory"
+	defaultOTPTTL   = 5 * time.Second
+)
+
+// LoadOTPConfig loads the one-time password configuration from the environment,
+// applying defaults if not set. It returns an error if OTP_TTL does not parse.
+func LoadOTPConfig() (OTPConfig, error) {
+	ttl, err := getDurationEnv("OTP_TTL", defaultOTPTTL)
+	if err != nil {
+		return OTPConfig{}, fmt.Errorf("invalid otp config: %w", err)
+	}
+
+	return OTPConfig{
+		STORE: getEnvWithDefault("OTP_STORE", defaultOTPStore),
+		TTL:   ttl,
+	}, nil
+}
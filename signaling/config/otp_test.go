@@ -0,0 +1,40 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadOTPConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "unset", ttl: "", want: defaultOTPTTL},
+		{name: "set", ttl: "30s", want: 30 * time.Second},
+		{name: "malformed", ttl: "5 minutes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTP_TTL", tt.ttl)
+
+			cfg, err := LoadOTPConfig()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "OTP_TTL") {
+					t.Fatalf("LoadOTPConfig() error = %v, want one naming OTP_TTL", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadOTPConfig: %v", err)
+			}
+			if cfg.TTL != tt.want {
+				t.Fatalf("TTL = %s, want %s", cfg.TTL, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package config
+
+// RecordingConfig is the configuration of server-side call recording.
+type RecordingConfig struct {
+	// DIR is where recordings are written, one subdirectory per recording.
+	DIR string
+}
+
+const defaultRecordingDir = "recordings"
+
+// LoadRecordingConfig loads the recording configuration from the environment,
+// applying defaults if not set.
+func LoadRecordingConfig() RecordingConfig {
+	return RecordingConfig{
+		DIR: getEnvWithDefault("RECORDING_DIR", defaultRecordingDir),
+	}
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLS modes select where the HTTPS server's certificate comes from.
+const (
+	// TLSModeSelfSigned uses TLS_CERT_FILE and TLS_KEY_FILE, generating a self-signed
+	// certificate for TLS_HOSTS into them first if they do not exist. Meant for development.
+	TLSModeSelfSigned = "self-signed"
+	// TLSModeFile uses TLS_CERT_FILE and TLS_KEY_FILE, which must exist.
+	TLSModeFile = "file"
+	// TLSModeACME obtains and renews certificates for ACME_DOMAINS from an ACME CA.
+	TLSModeACME = "acme"
+	// TLSModeOff serves plain HTTP, for running behind a TLS-terminating proxy.
+	TLSModeOff = "off"
+)
+
+// ServerConfig is the configuration of the HTTPS listener and of the websocket sessions.
+type ServerConfig struct {
+	// LISTEN_ADDR is the address the HTTPS server listens on.
+	LISTEN_ADDR string
+	// TLS_MODE is one of the TLSMode constants.
+	TLS_MODE string
+	// TLS_CERT_FILE and TLS_KEY_FILE are the PEM files the HTTPS server is started with.
+	TLS_CERT_FILE string
+	TLS_KEY_FILE  string
+	// TLS_HOSTS are the names and addresses a generated self-signed certificate is valid for.
+	TLS_HOSTS []string
+	// ACME_DOMAINS are the domains certificates are requested for in acme mode,
+	// ACME_EMAIL is the contact registered with the CA.
+	ACME_DOMAINS []string
+	ACME_EMAIL   string
+	// ACME_CACHE_DIR persists the ACME account key and the issued certificates.
+	ACME_CACHE_DIR string
+	// ACME_DIRECTORY_URL is the CA's directory, empty means Let's Encrypt. ACME_CA_FILE is
+	// an extra PEM root to trust when talking to it, e.g. the one of a local Pebble.
+	ACME_DIRECTORY_URL string
+	ACME_CA_FILE       string
+	// ACME_HTTP_ADDR is where HTTP-01 challenges are answered, empty relies on TLS-ALPN-01 only.
+	ACME_HTTP_ADDR string
+	// ALLOWED_ORIGINS are the Origin headers accepted on the websocket upgrade.
+	// A pattern may contain one "*", e.g. "https://*.example.com", a lone "*" accepts any origin.
+	ALLOWED_ORIGINS []string
+	// PONG_WAIT is how long a websocket may stay silent before it is considered dead,
+	// PING_INTERVAL is how often it is pinged and must be shorter.
+	PONG_WAIT     time.Duration
+	PING_INTERVAL time.Duration
+	// EGRESS_BUFFER is how many outgoing events are queued per client before the queue
+	// is full. A full queue drops its oldest EGRESS_DROPPABLE event to make room, other
+	// events are never dropped but queued over the limit, up to twice EGRESS_BUFFER.
+	// A client whose queue overflows that, or stays full for EGRESS_FULL_TIMEOUT, is
+	// disconnected.
+	EGRESS_BUFFER       int
+	EGRESS_DROPPABLE    []string
+	EGRESS_FULL_TIMEOUT time.Duration
+	// MAX_MESSAGE_SIZE is the largest websocket message accepted from a client, in bytes.
+	MAX_MESSAGE_SIZE int64
+}
+
+const (
+	defaultListenAddr        = ":9090"
+	defaultTLSMode           = TLSModeSelfSigned
+	defaultTLSHosts          = "localhost,127.0.0.1,::1"
+	defaultACMECacheDir      = "acme-cache"
+	defaultACMEHTTPAddr      = ":80"
+	defaultTLSCertFile       = "server.crt"
+	defaultTLSKeyFile        = "server.key"
+	defaultAllowedOrigins    = "https://localhost:9090"
+	defaultPongWait          = 10 * time.Second
+	defaultPingInterval      = (defaultPongWait * 9) / 10
+	defaultEgressBuffer      = 256
+	defaultEgressDroppable   = "new_message,user_join"
+	defaultEgressFullTimeout = 10 * time.Second
+	defaultMaxMessageSize    = 64 << 10
+)
+
+// serverSource looks a setting up in the environment first and in the config file second.
+type serverSource map[string]string
+
+func (s serverSource) get(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	if value := s[key]; value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// LoadServerConfig loads the server configuration and validates it. Settings are read
+// from the environment, then from the JSON file named by SERVER_CONFIG_FILE if it is
+// set, whose keys are the environment variable names, then defaults apply.
+// It returns an error for values that do not parse.
+func LoadServerConfig() (ServerConfig, error) {
+	source := serverSource{}
+	if path := os.Getenv("SERVER_CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ServerConfig{}, fmt.Errorf("failed to read server config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &source); err != nil {
+			return ServerConfig{}, fmt.Errorf("failed to parse server config file %s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	duration := func(key string, defaultValue time.Duration) time.Duration {
+		value, err := time.ParseDuration(source.get(key, defaultValue.String()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+		return value
+	}
+	integer := func(key string, defaultValue int64) int64 {
+		value, err := strconv.ParseInt(source.get(key, strconv.FormatInt(defaultValue, 10)), 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+		return value
+	}
+
+	cfg := ServerConfig{
+		LISTEN_ADDR:         source.get("SERVER_LISTEN_ADDR", defaultListenAddr),
+		TLS_MODE:            source.get("SERVER_TLS_MODE", defaultTLSMode),
+		TLS_CERT_FILE:       source.get("SERVER_TLS_CERT_FILE", defaultTLSCertFile),
+		TLS_KEY_FILE:        source.get("SERVER_TLS_KEY_FILE", defaultTLSKeyFile),
+		TLS_HOSTS:           splitList(source.get("SERVER_TLS_HOSTS", defaultTLSHosts)),
+		ACME_DOMAINS:        splitList(source.get("ACME_DOMAINS", "")),
+		ACME_EMAIL:          source.get("ACME_EMAIL", ""),
+		ACME_CACHE_DIR:      source.get("ACME_CACHE_DIR", defaultACMECacheDir),
+		ACME_DIRECTORY_URL:  source.get("ACME_DIRECTORY_URL", ""),
+		ACME_CA_FILE:        source.get("ACME_CA_FILE", ""),
+		ACME_HTTP_ADDR:      source.get("ACME_HTTP_ADDR", defaultACMEHTTPAddr),
+		ALLOWED_ORIGINS:     splitList(source.get("SERVER_ALLOWED_ORIGINS", defaultAllowedOrigins)),
+		PONG_WAIT:           duration("WS_PONG_WAIT", defaultPongWait),
+		PING_INTERVAL:       duration("WS_PING_INTERVAL", defaultPingInterval),
+		EGRESS_BUFFER:       int(integer("WS_EGRESS_BUFFER", defaultEgressBuffer)),
+		EGRESS_DROPPABLE:    splitList(source.get("WS_EGRESS_DROPPABLE", defaultEgressDroppable)),
+		EGRESS_FULL_TIMEOUT: duration("WS_EGRESS_FULL_TIMEOUT", defaultEgressFullTimeout),
+		MAX_MESSAGE_SIZE:    integer("WS_MAX_MESSAGE_SIZE", defaultMaxMessageSize),
+	}
+	if len(errs) > 0 {
+		return ServerConfig{}, fmt.Errorf("invalid server config: %w", errors.Join(errs...))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return ServerConfig{}, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every setting that is missing or out of range.
+func (c ServerConfig) Validate() error {
+	var errs []error
+
+	if c.LISTEN_ADDR == "" {
+		errs = append(errs, errors.New("listen address is required"))
+	}
+	switch c.TLS_MODE {
+	case TLSModeSelfSigned, TLSModeFile:
+		if c.TLS_CERT_FILE == "" || c.TLS_KEY_FILE == "" {
+			errs = append(errs, errors.New("tls cert and key files are required"))
+		}
+		if c.TLS_MODE == TLSModeSelfSigned && len(c.TLS_HOSTS) == 0 {
+			errs = append(errs, errors.New("at least one tls host is required for a self-signed certificate"))
+		}
+	case TLSModeACME:
+		if len(c.ACME_DOMAINS) == 0 {
+			errs = append(errs, errors.New("acme mode needs at least one domain"))
+		}
+		if c.ACME_CACHE_DIR == "" {
+			errs = append(errs, errors.New("acme mode needs a cache directory"))
+		}
+	case TLSModeOff:
+	default:
+		errs = append(errs, fmt.Errorf("unknown tls mode %q", c.TLS_MODE))
+	}
+	if len(c.ALLOWED_ORIGINS) == 0 {
+		errs = append(errs, errors.New("at least one allowed origin is required"))
+	}
+	for _, pattern := range c.ALLOWED_ORIGINS {
+		if strings.Count(pattern, "*") > 1 {
+			errs = append(errs, fmt.Errorf("origin pattern %q has more than one wildcard", pattern))
+		}
+	}
+	if c.PONG_WAIT <= 0 {
+		errs = append(errs, fmt.Errorf("pong wait must be positive, got %s", c.PONG_WAIT))
+	}
+	if c.PING_INTERVAL <= 0 || c.PING_INTERVAL >= c.PONG_WAIT {
+		errs = append(errs, fmt.Errorf("ping interval must be positive and shorter than the pong wait, got %s", c.PING_INTERVAL))
+	}
+	if c.EGRESS_BUFFER <= 0 {
+		errs = append(errs, fmt.Errorf("egress buffer must be positive, got %d", c.EGRESS_BUFFER))
+	}
+	if c.EGRESS_FULL_TIMEOUT <= 0 {
+		errs = append(errs, fmt.Errorf("egress full timeout must be positive, got %s", c.EGRESS_FULL_TIMEOUT))
+	}
+	if c.MAX_MESSAGE_SIZE <= 0 {
+		errs = append(errs, fmt.Errorf("max message size must be positive, got %d", c.MAX_MESSAGE_SIZE))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid server config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// AllowOrigin reports whether origin matches one of the allowed origin patterns.
+func (c ServerConfig) AllowOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+
+	for _, pattern := range c.ALLOWED_ORIGINS {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin matches origin against pattern, ignoring case. The "*" in the pattern
+// stands for any run of characters.
+func matchOrigin(pattern, origin string) bool {
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == origin
+	}
+
+	return len(origin) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
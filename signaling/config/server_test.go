@@ -0,0 +1,150 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"https://localhost:9090", "https://localhost:9090", true},
+		{"https://localhost:9090", "https://LOCALHOST:9090", true},
+		{"https://localhost:9090", "http://localhost:9090", false},
+		{"*", "https://anything.example", true},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://app.example.com.evil.net", false},
+		{"https://*.example.com", "http://app.example.com", false},
+		// the prefix and suffix may not overlap
+		{"https://a*a", "https://a", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchOrigin(tt.pattern, tt.origin); got != tt.want {
+			t.Errorf("matchOrigin(%q, %q) = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestAllowOriginRefusesEmptyOrigin(t *testing.T) {
+	cfg := ServerConfig{ALLOWED_ORIGINS: []string{"*"}}
+	if cfg.AllowOrigin("") {
+		t.Fatal("a request without an Origin header was allowed")
+	}
+}
+
+// validServerConfig returns a config Validate accepts.
+func validServerConfig() ServerConfig {
+	return ServerConfig{
+		LISTEN_ADDR:         defaultListenAddr,
+		TLS_MODE:            TLSModeSelfSigned,
+		TLS_CERT_FILE:       defaultTLSCertFile,
+		TLS_KEY_FILE:        defaultTLSKeyFile,
+		TLS_HOSTS:           []string{"localhost"},
+		ALLOWED_ORIGINS:     []string{defaultAllowedOrigins},
+		PONG_WAIT:           defaultPongWait,
+		PING_INTERVAL:       defaultPingInterval,
+		EGRESS_BUFFER:       defaultEgressBuffer,
+		EGRESS_FULL_TIMEOUT: defaultEgressFullTimeout,
+		MAX_MESSAGE_SIZE:    defaultMaxMessageSize,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(*ServerConfig)
+		wantErr string
+	}{
+		{name: "valid", change: func(*ServerConfig) {}},
+		{name: "tls off", change: func(c *ServerConfig) { c.TLS_MODE = TLSModeOff }},
+		{
+			name:    "no listen address",
+			change:  func(c *ServerConfig) { c.LISTEN_ADDR = "" },
+			wantErr: "listen address is required",
+		},
+		{
+			name:    "unknown tls mode",
+			change:  func(c *ServerConfig) { c.TLS_MODE = "letsencrypt" },
+			wantErr: `unknown tls mode "letsencrypt"`,
+		},
+		{
+			name:    "file mode without key",
+			change:  func(c *ServerConfig) { c.TLS_MODE, c.TLS_KEY_FILE = TLSModeFile, "" },
+			wantErr: "tls cert and key files are required",
+		},
+		{
+			name:    "self-signed without hosts",
+			change:  func(c *ServerConfig) { c.TLS_HOSTS = nil },
+			wantErr: "at least one tls host",
+		},
+		{
+			name:    "acme without domains",
+			change:  func(c *ServerConfig) { c.TLS_MODE, c.ACME_CACHE_DIR = TLSModeACME, defaultACMECacheDir },
+			wantErr: "acme mode needs at least one domain",
+		},
+		{
+			name:    "no origins",
+			change:  func(c *ServerConfig) { c.ALLOWED_ORIGINS = nil },
+			wantErr: "at least one allowed origin",
+		},
+		{
+			name:    "two wildcards",
+			change:  func(c *ServerConfig) { c.ALLOWED_ORIGINS = []string{"https://*.*.example.com"} },
+			wantErr: "more than one wildcard",
+		},
+		{
+			name:    "ping not shorter than pong wait",
+			change:  func(c *ServerConfig) { c.PING_INTERVAL = c.PONG_WAIT },
+			wantErr: "ping interval must be positive and shorter than the pong wait",
+		},
+		{
+			name:    "no egress buffer",
+			change:  func(c *ServerConfig) { c.EGRESS_BUFFER = 0 },
+			wantErr: "egress buffer must be positive",
+		},
+		{
+			name:    "negative full timeout",
+			change:  func(c *ServerConfig) { c.EGRESS_FULL_TIMEOUT = -time.Second },
+			wantErr: "egress full timeout must be positive",
+		},
+		{
+			name:    "no message size",
+			change:  func(c *ServerConfig) { c.MAX_MESSAGE_SIZE = 0 },
+			wantErr: "max message size must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validServerConfig()
+			tt.change(&cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadServerConfigRefusesMalformedDuration(t *testing.T) {
+	t.Setenv("SERVER_CONFIG_FILE", "")
+	t.Setenv("WS_PONG_WAIT", "ten seconds")
+
+	if _, err := LoadServerConfig(); err == nil || !strings.Contains(err.Error(), "WS_PONG_WAIT") {
+		t.Fatalf("LoadServerConfig() error = %v, want one naming WS_PONG_WAIT", err)
+	}
+}
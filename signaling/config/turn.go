@@ -0,0 +1,48 @@
+package config
+
+// TURNConfig is the configuration of the TURN/STUN server embedded in the signaling server.
+type TURNConfig struct {
+	// ENABLED starts the embedded server next to the HTTPS listener.
+	ENABLED bool
+	REALM   string
+	// LISTEN_ADDR is the local address the TURN listeners and relays bind to.
+	LISTEN_ADDR string
+	// PUBLIC_IP is the address handed to clients as the relay address.
+	PUBLIC_IP string
+	// UDP_PORT and TCP_PORT are the listening ports, zero disables that transport.
+	UDP_PORT int
+	TCP_PORT int
+	// RELAY_MIN_PORT and RELAY_MAX_PORT bound the ports relay allocations are made on.
+	RELAY_MIN_PORT int
+	RELAY_MAX_PORT int
+	// SECRET is the shared secret TURN REST credentials are signed with.
+	SECRET string
+	// MAX_ALLOCATIONS_PER_USER caps the concurrent relay allocations of a single user.
+	MAX_ALLOCATIONS_PER_USER int
+}
+
+const (
+	defaultTURNRealm             = "learn-webrtc"
+	defaultTURNListenAddr        = "0.0.0.0"
+	defaultTURNPort              = 3478
+	defaultTURNRelayMinPort      = 49152
+	defaultTURNRelayMaxPort      = 65535
+	defaultMaxAllocationsPerUser = 10
+)
+
+// LoadTURNConfig loads the embedded TURN server configuration from the environment,
+// applying defaults if not set.
+func LoadTURNConfig() TURNConfig {
+	return TURNConfig{
+		ENABLED:                  getBoolEnv("TURN_ENABLED", false),
+		REALM:                    getEnvWithDefault("TURN_REALM", defaultTURNRealm),
+		LISTEN_ADDR:              getEnvWithDefault("TURN_LISTEN_ADDR", defaultTURNListenAddr),
+		PUBLIC_IP:                getEnvWithDefault("TURN_PUBLIC_IP", ""),
+		UDP_PORT:                 getIntEnv("TURN_UDP_PORT", defaultTURNPort),
+		TCP_PORT:                 getIntEnv("TURN_TCP_PORT", defaultTURNPort),
+		RELAY_MIN_PORT:           getIntEnv("TURN_RELAY_MIN_PORT", defaultTURNRelayMinPort),
+		RELAY_MAX_PORT:           getIntEnv("TURN_RELAY_MAX_PORT", defaultTURNRelayMaxPort),
+		SECRET:                   getEnvWithDefault("TURN_SECRET", ""),
+		MAX_ALLOCATIONS_PER_USER: getIntEnv("TURN_MAX_ALLOCATIONS_PER_USER", defaultMaxAllocationsPerUser),
+	}
+}
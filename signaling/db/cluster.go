@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrPayloadNotFound is returned when a spilled notification payload was already deleted.
+var ErrPayloadNotFound = errors.New("cluster payload not found")
+
+// RoomMember is a row of the room_members table, a session in a room on one of the
+// cluster's nodes. Seq orders the members of all nodes by when they joined.
+type RoomMember struct {
+	SessionID string
+	NodeID    string
+	Room      string
+	UserID    int64
+	Username  string
+	Seq       int64
+	JoinedAt  time.Time
+}
+
+// ClusterRepository stores the nodes of a cluster and the room members connected to
+// each of them through a PgxPool.
+type ClusterRepository struct {
+	pool PgxPool
+}
+
+// NewClusterRepository returns a ClusterRepository backed by the given pool.
+func NewClusterRepository(pool PgxPool) *ClusterRepository {
+	return &ClusterRepository{pool: pool}
+}
+
+// Heartbeat registers the node, or refreshes its heartbeat if it is registered already.
+func (r *ClusterRepository) Heartbeat(ctx context.Context, nodeID string) error {
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO cluster_nodes (id) VALUES ($1)
+		ON CONFLICT (id) DO UPDATE SET heartbeat_at = now()`,
+		nodeID,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to store node heartbeat: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveNode deletes the node and, through the foreign key, its room members.
+func (r *ClusterRepository) RemoveNode(ctx context.Context, nodeID string) error {
+	if _, err := r.pool.Exec(ctx, `DELETE FROM cluster_nodes WHERE id = $1`, nodeID); err != nil {
+		return fmt.Errorf("unable to remove node: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveNodeMembers deletes the room members stored for the node and returns how many
+// were deleted. A node that restarts with its ID calls it, the members stored before it
+// stopped are not connected anymore.
+func (r *ClusterRepository) RemoveNodeMembers(ctx context.Context, nodeID string) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM room_members WHERE node_id = $1`, nodeID)
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete node members: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// DeleteStaleNodes deletes the nodes whose last heartbeat is older than before, with
+// their room members, and returns how many were deleted.
+func (r *ClusterRepository) DeleteStaleNodes(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM cluster_nodes WHERE heartbeat_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete stale nodes: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// AddMember stores that a session joined a room, replacing the room it was in before,
+// and returns the sequence number that orders it after every member that joined earlier.
+func (r *ClusterRepository) AddMember(ctx context.Context, member RoomMember) (int64, error) {
+	var seq int64
+	err := r.pool.QueryRow(ctx,
+		`INSERT INTO room_members (session_id, node_id, room, user_id, username)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (session_id) DO UPDATE SET
+			node_id = excluded.node_id, room = excluded.room,
+			seq = nextval('room_members_seq'), joined_at = now()
+		RETURNING seq`,
+		member.SessionID, member.NodeID, member.Room, member.UserID, member.Username,
+	).Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("unable to insert room member: %w", err)
+	}
+
+	return seq, nil
+}
+
+// RemoveMember deletes the session from room. It is a no-op if the session has moved
+// to another room since.
+func (r *ClusterRepository) RemoveMember(ctx context.Context, sessionID, room string) error {
+	_, err := r.pool.Exec(ctx,
+		`DELETE FROM room_members WHERE session_id = $1 AND room = $2`,
+		sessionID, room,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to delete room member: %w", err)
+	}
+
+	return nil
+}
+
+// Members returns the members of room on every node in the order they joined.
+func (r *ClusterRepository) Members(ctx context.Context, room string) ([]RoomMember, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT session_id, node_id, room, user_id, username, seq, joined_at
+		FROM room_members WHERE room = $1 ORDER BY seq`,
+		room,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query room members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []RoomMember
+	for rows.Next() {
+		var member RoomMember
+		if err := rows.Scan(&member.SessionID, &member.NodeID, &member.Room, &member.UserID,
+			&member.Username, &member.Seq, &member.JoinedAt); err != nil {
+			return nil, fmt.Errorf("unable to scan room member: %w", err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read room members: %w", err)
+	}
+
+	return members, nil
+}
+
+// SavePayload stores a notification payload too large for NOTIFY and returns its ID,
+// the notification then only carries the ID.
+func (r *ClusterRepository) SavePayload(ctx context.Context, payload string) (int64, error) {
+	var id int64
+	err := r.pool.QueryRow(ctx,
+		`INSERT INTO cluster_payloads (payload) VALUES ($1) RETURNING id`, payload,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("unable to insert cluster payload: %w", err)
+	}
+
+	return id, nil
+}
+
+// LoadPayload returns a payload stored with SavePayload. It returns ErrPayloadNotFound
+// if the payload was deleted.
+func (r *ClusterRepository) LoadPayload(ctx context.Context, id int64) (string, error) {
+	var payload string
+	err := r.pool.QueryRow(ctx, `SELECT payload FROM cluster_payloads WHERE id = $1`, id).Scan(&payload)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrPayloadNotFound
+		}
+		return "", fmt.Errorf("unable to load cluster payload: %w", err)
+	}
+
+	return payload, nil
+}
+
+// DeletePayloadsBefore deletes the payloads stored before the given time, every node
+// has read them by then.
+func (r *ClusterRepository) DeletePayloadsBefore(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM cluster_payloads WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete cluster payloads: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Notify sends payload to the listeners of channel.
+func (r *ClusterRepository) Notify(ctx context.Context, channel, payload string) error {
+	if _, err := r.pool.Exec(ctx, `SELECT pg_notify($1, $2)`, channel, payload); err != nil {
+		return fmt.Errorf("unable to notify %s: %w", channel, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+)
+
+// InitPSQL initializes a PostgreSQL connection pool and ensures successful connection to the database.
+// It sets up a structured logger, creates the connection pool, acquires a connection, and pings the database.
+// Returns an error if any step of the initialization fails.
+func InitDB() (*pgxpool.Pool, error) {
+	dbConfig, err := config.PSQLConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	connPool, err := pgxpool.NewWithConfig(context.Background(), dbConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize pgx pool from configuration: %w", err)
+	}
+	if err := Ping(context.Background(), connPool); err != nil {
+		// the caller gets no pool to close, its connections would leak
+		connPool.Close()
+		return nil, err
+	}
+
+	slog.Info("successfully connected to database")
+	return connPool, nil
+}
+
+// Ping acquires a connection from the pool and pings the database with it.
+// InitDB uses it to check the connection and the readiness probe to recheck it.
+func Ping(ctx context.Context, pool PgxPool) error {
+	if err := pool.Ping(ctx); err != nil {
+		return fmt.Errorf("unable to ping the connection pool: %w", err)
+	}
+	return nil
+}
+
+// ClosePSQL Close the postgreSQL connection pool and logs the closure.
+func ClosePSQL(pool *pgxpool.Pool) {
+	pool.Close()
+	slog.Info("database connection closed")
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// listenerRetryInterval is how long Listener waits before reconnecting after its
+// connection failed.
+const listenerRetryInterval = time.Second
+
+// Listener receives Postgres notifications on a dedicated connection, a pooled one
+// would lose its LISTEN registrations when it is handed to someone else. Channels can
+// be added and removed while it runs, and they are listened to again after a reconnect.
+// Notifications sent while it is reconnecting are lost.
+type Listener struct {
+	config  *pgx.ConnConfig
+	handler func(channel, payload string)
+
+	mu       sync.Mutex
+	channels map[string]bool
+	// version counts the changes to channels, applied is the version the connection
+	// listens according to. synced is closed and replaced whenever applied grows.
+	version uint64
+	applied uint64
+	synced  chan struct{}
+	// wake interrupts the wait for a notification so channel changes are applied.
+	wake chan struct{}
+}
+
+// NewListener returns a Listener that connects with config and calls handler for every
+// notification, from the goroutine Run is called on.
+func NewListener(config *pgx.ConnConfig, handler func(channel, payload string)) *Listener {
+	return &Listener{
+		config:   config,
+		handler:  handler,
+		channels: make(map[string]bool),
+		synced:   make(chan struct{}),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Listen starts listening to channel and returns once the LISTEN was executed, so
+// every notification sent afterwards is received. If ctx is done first it returns ctx's
+// error, the channel is still listened to as soon as the connection allows.
+func (l *Listener) Listen(ctx context.Context, channel string) error {
+	l.mu.Lock()
+	l.channels[channel] = true
+	l.version++
+	version := l.version
+	l.mu.Unlock()
+
+	l.interrupt()
+	return l.wait(ctx, version)
+}
+
+// Unlisten stops listening to channel, it takes effect asynchronously.
+func (l *Listener) Unlisten(channel string) {
+	l.mu.Lock()
+	delete(l.channels, channel)
+	l.version++
+	l.mu.Unlock()
+
+	l.interrupt()
+}
+
+// wait returns once the connection listens according to version of the channels.
+func (l *Listener) wait(ctx context.Context, version uint64) error {
+	for {
+		l.mu.Lock()
+		applied, synced := l.applied, l.synced
+		l.mu.Unlock()
+
+		if applied >= version {
+			return nil
+		}
+		select {
+		case <-synced:
+		case <-ctx.Done():
+			return fmt.Errorf("channel is not listened to yet: %w", ctx.Err())
+		}
+	}
+}
+
+func (l *Listener) interrupt() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+		// a wake up is pending already
+	}
+}
+
+// Run receives notifications until ctx is cancelled, reconnecting whenever the
+// connection fails. It returns ctx's error.
+func (l *Listener) Run(ctx context.Context) error {
+	for {
+		conn, err := pgx.ConnectConfig(ctx, l.config)
+		if err == nil {
+			err = l.receive(ctx, conn)
+			conn.Close(context.Background())
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Error("notification listener failed, reconnecting", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(listenerRetryInterval):
+		}
+	}
+}
+
+// receive applies the channel changes and waits for notifications on conn until it fails.
+func (l *Listener) receive(ctx context.Context, conn *pgx.Conn) error {
+	listening := make(map[string]bool)
+
+	for {
+		if err := l.sync(ctx, conn, listening); err != nil {
+			return err
+		}
+
+		waitCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-l.wake:
+				cancel()
+			case <-waitCtx.Done():
+			}
+		}()
+
+		notification, err := conn.WaitForNotification(waitCtx)
+		woken := waitCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+
+		if err != nil {
+			if woken && errors.Is(err, context.Canceled) {
+				continue
+			}
+			return err
+		}
+
+		l.handler(notification.Channel, notification.Payload)
+	}
+}
+
+// sync issues the LISTEN and UNLISTEN statements that make listening match the channels,
+// then wakes the Listen calls waiting for them.
+func (l *Listener) sync(ctx context.Context, conn *pgx.Conn, listening map[string]bool) error {
+	l.mu.Lock()
+	version := l.version
+	var listen, unlisten []string
+	for channel := range l.channels {
+		if !listening[channel] {
+			listen = append(listen, channel)
+		}
+	}
+	for channel := range listening {
+		if !l.channels[channel] {
+			unlisten = append(unlisten, channel)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, channel := range listen {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return fmt.Errorf("unable to listen to %s: %w", channel, err)
+		}
+		listening[channel] = true
+	}
+	for _, channel := range unlisten {
+		if _, err := conn.Exec(ctx, "UNLISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return fmt.Errorf("unable to unlisten %s: %w", channel, err)
+		}
+		delete(listening, channel)
+	}
+
+	l.mu.Lock()
+	if version > l.applied {
+		l.applied = version
+		close(l.synced)
+		l.synced = make(chan struct{})
+	}
+	l.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Message is a single chat message persisted in the messages table.
+type Message struct {
+	ID     int64
+	Room   string
+	Sender string
+	Body   string
+	SentAt time.Time
+}
+
+// MessageRepository stores and loads chat messages through a PgxPool.
+type MessageRepository struct {
+	pool PgxPool
+}
+
+// NewMessageRepository returns a MessageRepository backed by the given pool.
+func NewMessageRepository(pool PgxPool) *MessageRepository {
+	return &MessageRepository{pool: pool}
+}
+
+// Save inserts a message and returns it with the ID and timestamp assigned by the database.
+func (r *MessageRepository) Save(ctx context.Context, room, sender, body string) (Message, error) {
+	msg := Message{Room: room, Sender: sender, Body: body}
+
+	err := r.pool.QueryRow(ctx,
+		`INSERT INTO messages (room, sender, body) VALUES ($1, $2, $3) RETURNING id, sent_at`,
+		room, sender, body,
+	).Scan(&msg.ID, &msg.SentAt)
+	if err != nil {
+		return Message{}, fmt.Errorf("unable to insert message: %w", err)
+	}
+
+	return msg, nil
+}
+
+// History returns up to limit messages of a room that are older than the before cursor,
+// ordered from oldest to newest. A before value of zero starts from the latest message.
+func (r *MessageRepository) History(ctx context.Context, room string, before int64, limit int) ([]Message, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT id, room, sender, body, sent_at FROM messages
+		WHERE room = $1 AND ($2::bigint = 0 OR id < $2::bigint)
+		ORDER BY id DESC
+		LIMIT $3`,
+		room, before, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query room history: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		if err := rows.Scan(&msg.ID, &msg.Room, &msg.Sender, &msg.Body, &msg.SentAt); err != nil {
+			return nil, fmt.Errorf("unable to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read room history: %w", err)
+	}
+
+	// rows come newest first so the LIMIT picks the latest page; flip them for display.
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
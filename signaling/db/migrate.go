@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the key of the advisory lock held while migrating, so instances
+// starting at the same time apply each migration once.
+const migrationLock int64 = 0x6c7274632d6d6967
+
+// Migrate applies every migration under db/migrations that has not been recorded yet.
+// Migrations are applied in lexical order of their file name and each applied version
+// is stored in the schema_migrations table, so calling Migrate on every start is safe.
+// Each migration is applied in a transaction together with its version row, under an
+// advisory lock, so a failed migration leaves nothing behind and concurrent callers
+// wait for each other.
+func Migrate(ctx context.Context, pool PgxPool) error {
+	err := migrateTx(ctx, pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    TEXT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create schema_migrations table: %w", err)
+	}
+
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return fmt.Errorf("unable to list migrations: %w", err)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+
+		sql, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("unable to read migration %s: %w", version, err)
+		}
+
+		applied := false
+		err = migrateTx(ctx, pool, func(tx pgx.Tx) error {
+			var done bool
+			err := tx.QueryRow(ctx,
+				`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version,
+			).Scan(&done)
+			if err != nil {
+				return fmt.Errorf("unable to check migration %s: %w", version, err)
+			}
+			if done {
+				return nil
+			}
+
+			if _, err := tx.Exec(ctx, string(sql)); err != nil {
+				return fmt.Errorf("unable to apply migration %s: %w", version, err)
+			}
+			if _, err := tx.Exec(ctx,
+				`INSERT INTO schema_migrations (version) VALUES ($1)`, version,
+			); err != nil {
+				return fmt.Errorf("unable to record migration %s: %w", version, err)
+			}
+
+			applied = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if applied {
+			slog.Info("applied database migration", "version", version)
+		}
+	}
+
+	return nil
+}
+
+// migrateTx runs fn in a transaction holding the migration lock and commits it if fn
+// succeeds. The lock is released when the transaction ends.
+func migrateTx(ctx context.Context, pool PgxPool, fn func(tx pgx.Tx) error) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to begin migration transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return fmt.Errorf("unable to take the migration lock: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("unable to commit migration transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS messages (
+    id         BIGSERIAL PRIMARY KEY,
+    room       TEXT        NOT NULL,
+    sender     TEXT        NOT NULL,
+    body       TEXT        NOT NULL,
+    sent_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS messages_room_id_idx ON messages (room, id DESC);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS users (
+    id              BIGSERIAL PRIMARY KEY,
+    username        TEXT        NOT NULL UNIQUE,
+    password_hash   TEXT        NOT NULL,
+    failed_attempts INTEGER     NOT NULL DEFAULT 0,
+    locked_until    TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS otps (
+    key        TEXT PRIMARY KEY,
+    user_id    BIGINT      NOT NULL,
+    username   TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS otps_expires_at_idx ON otps (expires_at);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS recordings (
+    id          BIGSERIAL PRIMARY KEY,
+    room        TEXT        NOT NULL,
+    started_by  BIGINT      NOT NULL REFERENCES users (id),
+    started_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    stopped_at  TIMESTAMPTZ,
+    duration_ms BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS recordings_room_idx ON recordings (room, started_at DESC);
+
+CREATE TABLE IF NOT EXISTS recording_files (
+    id           BIGSERIAL PRIMARY KEY,
+    recording_id BIGINT      NOT NULL REFERENCES recordings (id) ON DELETE CASCADE,
+    user_id      BIGINT      NOT NULL,
+    username     TEXT        NOT NULL,
+    session_id   TEXT        NOT NULL,
+    kind         TEXT        NOT NULL,
+    codec        TEXT        NOT NULL,
+    path         TEXT        NOT NULL,
+    started_at   TIMESTAMPTZ NOT NULL,
+    duration_ms  BIGINT      NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS recording_files_recording_id_idx ON recording_files (recording_id);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS cluster_nodes (
+    id           TEXT PRIMARY KEY,
+    started_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE SEQUENCE IF NOT EXISTS room_members_seq;
+
+CREATE TABLE IF NOT EXISTS room_members (
+    session_id TEXT PRIMARY KEY,
+    node_id    TEXT        NOT NULL REFERENCES cluster_nodes (id) ON DELETE CASCADE,
+    room       TEXT        NOT NULL,
+    user_id    BIGINT      NOT NULL,
+    username   TEXT        NOT NULL,
+    seq        BIGINT      NOT NULL DEFAULT nextval('room_members_seq'),
+    joined_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS room_members_room_idx ON room_members (room, seq);
+
+CREATE TABLE IF NOT EXISTS cluster_payloads (
+    id         BIGSERIAL PRIMARY KEY,
+    payload    TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrOTPNotFound is returned when a one-time password does not exist, has expired or was already used.
+var ErrOTPNotFound = errors.New("otp not found")
+
+// OTP is a one-time password row stored in the otps table.
+type OTP struct {
+	Key       string
+	UserID    int64
+	Username  string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// OTPRepository stores one-time passwords through a PgxPool so that
+// several server instances can redeem tickets issued by each other.
+type OTPRepository struct {
+	pool PgxPool
+}
+
+// NewOTPRepository returns an OTPRepository backed by the given pool.
+func NewOTPRepository(pool PgxPool) *OTPRepository {
+	return &OTPRepository{pool: pool}
+}
+
+// Insert stores a new one-time password that expires ttl from now and returns it with
+// its CreatedAt and ExpiresAt set. Both come from the database's clock, the one
+// Consume and DeleteExpired compare them with, so the app servers' clocks do not matter.
+func (r *OTPRepository) Insert(ctx context.Context, otp OTP, ttl time.Duration) (OTP, error) {
+	err := r.pool.QueryRow(ctx,
+		`INSERT INTO otps (key, user_id, username, created_at, expires_at)
+		VALUES ($1, $2, $3, now(), now() + $4 * interval '1 microsecond')
+		RETURNING created_at, expires_at`,
+		otp.Key, otp.UserID, otp.Username, ttl.Microseconds(),
+	).Scan(&otp.CreatedAt, &otp.ExpiresAt)
+	if err != nil {
+		return OTP{}, fmt.Errorf("unable to insert otp: %w", err)
+	}
+
+	return otp, nil
+}
+
+// Consume deletes an unexpired one-time password and returns it. The delete and the
+// read happen in one statement, so only one of several concurrent callers gets the row.
+// It returns ErrOTPNotFound if the key is unknown, expired or already consumed.
+func (r *OTPRepository) Consume(ctx context.Context, key string) (OTP, error) {
+	otp := OTP{Key: key}
+
+	err := r.pool.QueryRow(ctx,
+		`DELETE FROM otps WHERE key = $1 AND expires_at > now()
+		RETURNING user_id, username, created_at, expires_at`,
+		key,
+	).Scan(&otp.UserID, &otp.Username, &otp.CreatedAt, &otp.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return OTP{}, ErrOTPNotFound
+		}
+		return OTP{}, fmt.Errorf("unable to consume otp: %w", err)
+	}
+
+	return otp, nil
+}
+
+// DeleteExpired removes every one-time password whose expiry has passed.
+func (r *OTPRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM otps WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete expired otps: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
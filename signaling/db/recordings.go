@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Recording is a row of the recordings table, one archived session of a room.
+type Recording struct {
+	ID        int64
+	Room      string
+	StartedBy int64
+	StartedAt time.Time
+}
+
+// RecordingFile is a row of the recording_files table, the audio or video track
+// of one participant written to Path.
+type RecordingFile struct {
+	ID          int64
+	RecordingID int64
+	UserID      int64
+	Username    string
+	SessionID   string
+	Kind        string
+	Codec       string
+	Path        string
+	StartedAt   time.Time
+	Duration    time.Duration
+}
+
+// RecordingRepository stores recordings and their files through a PgxPool.
+type RecordingRepository struct {
+	pool PgxPool
+}
+
+// NewRecordingRepository returns a RecordingRepository backed by the given pool.
+func NewRecordingRepository(pool PgxPool) *RecordingRepository {
+	return &RecordingRepository{pool: pool}
+}
+
+// Start inserts a running recording of room and returns it with the ID and
+// start time assigned by the database.
+func (r *RecordingRepository) Start(ctx context.Context, room string, startedBy int64) (Recording, error) {
+	rec := Recording{Room: room, StartedBy: startedBy}
+
+	err := r.pool.QueryRow(ctx,
+		`INSERT INTO recordings (room, started_by) VALUES ($1, $2) RETURNING id, started_at`,
+		room, startedBy,
+	).Scan(&rec.ID, &rec.StartedAt)
+	if err != nil {
+		return Recording{}, fmt.Errorf("unable to insert recording: %w", err)
+	}
+
+	return rec, nil
+}
+
+// Delete removes a recording that never got any file, e.g. because its directory
+// could not be created.
+func (r *RecordingRepository) Delete(ctx context.Context, id int64) error {
+	if _, err := r.pool.Exec(ctx, `DELETE FROM recordings WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("unable to delete recording: %w", err)
+	}
+
+	return nil
+}
+
+// Stop marks a recording as finished at stoppedAt and stores its duration.
+func (r *RecordingRepository) Stop(ctx context.Context, id int64, stoppedAt time.Time) error {
+	_, err := r.pool.Exec(ctx,
+		`UPDATE recordings
+		SET stopped_at = $2, duration_ms = (EXTRACT(EPOCH FROM ($2 - started_at)) * 1000)::bigint
+		WHERE id = $1`,
+		id, stoppedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to stop recording: %w", err)
+	}
+
+	return nil
+}
+
+// AddFile stores a finished file of a recording.
+func (r *RecordingRepository) AddFile(ctx context.Context, file RecordingFile) error {
+	_, err := r.pool.Exec(ctx,
+		`INSERT INTO recording_files
+		(recording_id, user_id, username, session_id, kind, codec, path, started_at, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		file.RecordingID, file.UserID, file.Username, file.SessionID, file.Kind, file.Codec,
+		file.Path, file.StartedAt, file.Duration.Milliseconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to insert recording file: %w", err)
+	}
+
+	return nil
+}
+
+// Files returns the files of a recording in the order they were finished.
+func (r *RecordingRepository) Files(ctx context.Context, recordingID int64) ([]RecordingFile, error) {
+	rows, err := r.pool.Query(ctx,
+		`SELECT id, recording_id, user_id, username, session_id, kind, codec, path, started_at, duration_ms
+		FROM recording_files WHERE recording_id = $1 ORDER BY id`,
+		recordingID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query recording files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []RecordingFile
+	for rows.Next() {
+		var file RecordingFile
+		var durationMs int64
+		if err := rows.Scan(&file.ID, &file.RecordingID, &file.UserID, &file.Username, &file.SessionID,
+			&file.Kind, &file.Codec, &file.Path, &file.StartedAt, &durationMs); err != nil {
+			return nil, fmt.Errorf("unable to scan recording file: %w", err)
+		}
+		file.Duration = time.Duration(durationMs) * time.Millisecond
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read recording files: %w", err)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrUserNotFound is returned when no user matches the given username.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when registering a username that is already taken.
+	ErrUserExists = errors.New("user already exists")
+)
+
+// uniqueViolation is the postgres error code raised when a unique constraint fails.
+const uniqueViolation = "23505"
+
+// User is an account stored in the users table.
+type User struct {
+	ID             int64
+	Username       string
+	PasswordHash   string
+	FailedAttempts int
+	LockedUntil    *time.Time
+	CreatedAt      time.Time
+}
+
+// Locked reports whether the account is locked at the given time.
+func (u User) Locked(now time.Time) bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(now)
+}
+
+// UserRepository stores and loads user accounts through a PgxPool.
+type UserRepository struct {
+	pool PgxPool
+}
+
+// NewUserRepository returns a UserRepository backed by the given pool.
+func NewUserRepository(pool PgxPool) *UserRepository {
+	return &UserRepository{pool: pool}
+}
+
+// Create inserts a new user with an already hashed password.
+// It returns ErrUserExists if the username is taken.
+func (r *UserRepository) Create(ctx context.Context, username, passwordHash string) (User, error) {
+	user := User{Username: username, PasswordHash: passwordHash}
+
+	err := r.pool.QueryRow(ctx,
+		`INSERT INTO users (username, password_hash) VALUES ($1, $2) RETURNING id, created_at`,
+		username, passwordHash,
+	).Scan(&user.ID, &user.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return User{}, ErrUserExists
+		}
+		return User{}, fmt.Errorf("unable to insert user: %w", err)
+	}
+
+	return user, nil
+}
+
+// FindByUsername loads a user by username. It returns ErrUserNotFound if there is none.
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (User, error) {
+	var user User
+
+	err := r.pool.QueryRow(ctx,
+		`SELECT id, username, password_hash, failed_attempts, locked_until, created_at
+		FROM users WHERE username = $1`,
+		username,
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.FailedAttempts, &user.LockedUntil, &user.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, fmt.Errorf("unable to query user: %w", err)
+	}
+
+	return user, nil
+}
+
+// RecordFailedLogin increments the failed attempt counter of a user. Once the counter
+// reaches maxAttempts the account is locked for lockout and the counter starts over.
+// It returns the time the account is locked until, or nil if it is not locked.
+func (r *UserRepository) RecordFailedLogin(ctx context.Context, id int64, maxAttempts int, lockout time.Duration) (*time.Time, error) {
+	var lockedUntil *time.Time
+
+	err := r.pool.QueryRow(ctx,
+		`UPDATE users SET
+			failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN now() + $3::interval ELSE locked_until END
+		WHERE id = $1
+		RETURNING locked_until`,
+		id, maxAttempts, lockout,
+	).Scan(&lockedUntil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to record failed login: %w", err)
+	}
+
+	return lockedUntil, nil
+}
+
+// ResetFailedLogins clears the failed attempt counter and any lock after a successful login.
+func (r *UserRepository) ResetFailedLogins(ctx context.Context, id int64) error {
+	_, err := r.pool.Exec(ctx,
+		`UPDATE users SET failed_attempts = 0, locked_until = NULL WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to reset failed logins: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package signaling
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errEgressOverflow is returned when an event that cannot be dropped finds the queue
+// holding twice its limit, the client is disconnected then.
+var errEgressOverflow = errors.New("egress overflowed")
+
+// egressQueue is a client's queue of outgoing events, the write goroutine is its only
+// consumer. Queueing never blocks the sender. Once limit events are queued the queue
+// is full, and the oldest droppable event is dropped to make room for a new one; if
+// none is queued a new droppable event is dropped itself, while any other event, e.g.
+// signaling, is queued over the limit, up to twice the limit. Once closed it refuses
+// new events, and the write goroutine ends the websocket with its close frame after
+// the queued ones.
+type egressQueue struct {
+	limit     int
+	droppable map[string]bool
+
+	mu     sync.Mutex
+	events []Event
+	// fullSince is when the queue last became full, zero while it is not.
+	fullSince time.Time
+	dropped   uint64
+	// closed is set by close, frame is the close frame to end the websocket with.
+	closed bool
+	frame  []byte
+	// ready holds a value while events is not empty or the queue is closed.
+	ready chan struct{}
+}
+
+// EgressStats describes a client's queue of outgoing events.
+type EgressStats struct {
+	// Queued is the number of events waiting to be written.
+	Queued int
+	// Dropped is the number of events dropped because the queue was full.
+	Dropped uint64
+}
+
+func newEgressQueue(limit int, droppable []string) *egressQueue {
+	q := &egressQueue{
+		limit:     limit,
+		droppable: make(map[string]bool, len(droppable)),
+		ready:     make(chan struct{}, 1),
+	}
+	for _, eventType := range droppable {
+		q.droppable[eventType] = true
+	}
+	return q
+}
+
+// push queues event. It returns the reason an event was dropped to make room, or an
+// empty string, and how long the queue has been full. It fails with ErrClientClosed
+// once the queue is closed, and with errEgressOverflow if event cannot be dropped nor
+// queued.
+func (q *egressQueue) push(event Event) (dropReason string, fullFor time.Duration, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return "", 0, ErrClientClosed
+	}
+
+	now := time.Now()
+	if len(q.events) >= q.limit {
+		if q.fullSince.IsZero() {
+			q.fullSince = now
+		}
+		fullFor = now.Sub(q.fullSince)
+
+		if oldest := q.oldestDroppable(); oldest >= 0 {
+			q.events = append(q.events[:oldest], q.events[oldest+1:]...)
+			q.dropped++
+			dropReason = dropOldest
+		} else if q.droppable[event.Type] {
+			q.dropped++
+			return dropNewest, fullFor, nil
+		} else if len(q.events) >= 2*q.limit {
+			return "", fullFor, errEgressOverflow
+		}
+	}
+
+	q.events = append(q.events, event)
+	if len(q.events) >= q.limit && q.fullSince.IsZero() {
+		q.fullSince = now
+	}
+	q.signal()
+
+	return dropReason, fullFor, nil
+}
+
+// requeue puts event back at the front of the queue after the write goroutine failed
+// to write it, so it is written first once the session is resumed. It is kept even
+// when the queue is closed meanwhile, it was queued before.
+func (q *egressQueue) requeue(event Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.events = append([]Event{event}, q.events...)
+	if len(q.events) >= q.limit && q.fullSince.IsZero() {
+		q.fullSince = time.Now()
+	}
+	q.signal()
+}
+
+// oldestDroppable returns the index of the first queued droppable event, or -1.
+func (q *egressQueue) oldestDroppable() int {
+	for i, event := range q.events {
+		if q.droppable[event.Type] {
+			return i
+		}
+	}
+	return -1
+}
+
+// pop takes the oldest event off the queue, ok is false if it is empty.
+func (q *egressQueue) pop() (event Event, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.events) == 0 {
+		return Event{}, false
+	}
+
+	event = q.events[0]
+	q.events[0] = Event{}
+	q.events = q.events[1:]
+	if len(q.events) < q.limit {
+		q.fullSince = time.Time{}
+	}
+	if len(q.events) > 0 || q.closed {
+		// the write goroutine takes one event per wake up, so it still pings in between
+		q.signal()
+	}
+	return event, true
+}
+
+// close refuses new events and wakes the write goroutine, which sends the queued
+// events and then frame.
+func (q *egressQueue) close(frame []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.frame = frame
+	q.signal()
+}
+
+// closeFrame returns the frame passed to close, closed is false if it was not called.
+func (q *egressQueue) closeFrame() (frame []byte, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.frame, q.closed
+}
+
+func (q *egressQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+		// a wake up is pending already
+	}
+}
+
+func (q *egressQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.events)
+}
+
+func (q *egressQueue) stats() EgressStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return EgressStats{Queued: len(q.events), Dropped: q.dropped}
+}
@@ -0,0 +1,125 @@
+package signaling
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+func TestEgressQueueDropsDroppable(t *testing.T) {
+	q := newEgressQueue(2, []string{EventNewMessage})
+
+	q.push(Event{Type: EventNewMessage, ID: "1"})
+	q.push(Event{Type: EventOffer})
+	if reason, _, err := q.push(Event{Type: EventAnswer}); err != nil || reason != dropOldest {
+		t.Fatalf("push to a full queue = %q, %v, want the oldest droppable event dropped", reason, err)
+	}
+	if reason, _, err := q.push(Event{Type: EventNewMessage, ID: "2"}); err != nil || reason != dropNewest {
+		t.Fatalf("push of a droppable event = %q, %v, want it dropped", reason, err)
+	}
+
+	for _, want := range []string{EventOffer, EventAnswer} {
+		if event, ok := q.pop(); !ok || event.Type != want {
+			t.Fatalf("popped %q, want %q", event.Type, want)
+		}
+	}
+	if stats := q.stats(); stats.Dropped != 2 || stats.Queued != 0 {
+		t.Fatalf("stats = %+v, want 2 dropped and none queued", stats)
+	}
+}
+
+func TestEgressQueueOverflow(t *testing.T) {
+	const limit = 4
+	q := newEgressQueue(limit, nil)
+
+	// events that cannot be dropped are queued over the limit, up to twice the limit
+	for i := 0; i < 2*limit; i++ {
+		if _, _, err := q.push(Event{Type: EventOffer}); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+	if _, _, err := q.push(Event{Type: EventOffer}); !errors.Is(err, errEgressOverflow) {
+		t.Fatalf("push past twice the limit = %v, want %v", err, errEgressOverflow)
+	}
+	if n := q.len(); n != 2*limit {
+		t.Fatalf("%d events queued, want %d", n, 2*limit)
+	}
+}
+
+func TestEgressQueueRequeue(t *testing.T) {
+	q := newEgressQueue(4, nil)
+
+	q.push(Event{Type: EventOffer})
+	q.push(Event{Type: EventAnswer})
+	event, _ := q.pop()
+	q.requeue(event)
+
+	for _, want := range []string{EventOffer, EventAnswer} {
+		if event, ok := q.pop(); !ok || event.Type != want {
+			t.Fatalf("popped %q, want %q", event.Type, want)
+		}
+	}
+}
+
+func TestDeliverOverflowDisconnects(t *testing.T) {
+	c := newTestClient(t, nil)
+	limit := c.manager.server.EGRESS_BUFFER
+
+	for i := 0; i < 2*limit; i++ {
+		if err := c.deliver(Event{Type: EventOffer}); err != nil {
+			t.Fatalf("deliver %d: %v", i, err)
+		}
+	}
+	if err := c.deliver(Event{Type: EventOffer}); !errors.Is(err, errEgressOverflow) {
+		t.Fatalf("deliver past the overflow = %v, want %v", err, errEgressOverflow)
+	}
+
+	if !c.slow.Load() {
+		t.Fatal("client was not disconnected as slow")
+	}
+	if c.Context().Err() == nil {
+		t.Fatal("client context is not cancelled")
+	}
+}
+
+func TestRemoveClientReportsDrops(t *testing.T) {
+	c := newTestClient(t, nil)
+	c.egress = newEgressQueue(2, []string{EventNewMessage})
+	m := c.manager
+	m.addClient(c)
+
+	for i := 0; i < 5; i++ {
+		c.deliver(Event{Type: EventNewMessage})
+	}
+
+	records := captureLogs(t)
+	m.removeClient(c)
+
+	var dropped uint64
+	waitRecord(t, records, "session ended").Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "dropped" {
+			dropped = attr.Value.Uint64()
+		}
+		return true
+	})
+	if dropped != 3 {
+		t.Fatalf("session ended with %d dropped, want 3", dropped)
+	}
+
+	families, err := m.metrics.registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "signaling_client_egress_drops" {
+			continue
+		}
+		histogram := family.GetMetric()[0].GetHistogram()
+		if histogram.GetSampleCount() != 1 || histogram.GetSampleSum() != 3 {
+			t.Fatalf("client drops histogram has %d samples summing to %v, want one of 3",
+				histogram.GetSampleCount(), histogram.GetSampleSum())
+		}
+		return
+	}
+	t.Fatal("client drops histogram is not exported")
+}
@@ -0,0 +1,69 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// HandlerError is the error a handler returns when the client's event cannot be handled,
+// the client gets Code and Message in an error event. Any other error a handler
+// returns is sent as ErrCodeInternal, its details are only logged.
+type HandlerError struct {
+	Code    string
+	Message string
+}
+
+// NewHandlerError returns a HandlerError with the given code and message.
+func NewHandlerError(code, message string) *HandlerError {
+	return &HandlerError{Code: code, Message: message}
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// decodePayload unmarshals the payload of event into v, failing with ErrCodeBadRequest.
+func decodePayload(event Event, v any) error {
+	if err := json.Unmarshal(event.Payload, v); err != nil {
+		return NewHandlerError(ErrCodeBadRequest, fmt.Sprintf("bad %s payload: %v", event.Type, err))
+	}
+	return nil
+}
+
+// replyError tells the client that request failed with err and logs why. Events the
+// client got wrong are logged at info level, failures of the server as errors.
+func (c *Client) replyError(ctx context.Context, request Event, err error) {
+	if c.ctx.Err() != nil {
+		// the client is closing, there is nobody left to tell
+		slog.DebugContext(ctx, "event failed while the client was closing", "error", err)
+		return
+	}
+
+	var eventErr *HandlerError
+	if errors.As(err, &eventErr) {
+		slog.InfoContext(ctx, "event rejected", "code", eventErr.Code, "error", err)
+	} else {
+		slog.ErrorContext(ctx, "failed to handle event", "error", err)
+		eventErr = NewHandlerError(ErrCodeInternal, "the server failed to handle the event")
+	}
+	c.manager.metrics.errorReplies.WithLabelValues(eventErr.Code).Inc()
+
+	data, err := json.Marshal(ErrorEvent{
+		Code:      eventErr.Code,
+		Message:   eventErr.Message,
+		Event:     request.Type,
+		RequestID: request.ID,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to marshal error event", "error", err)
+		return
+	}
+
+	// the handler's ctx may be cancelled already, the reply is queued regardless
+	if err := c.deliver(Event{Type: EventError, Payload: data}); err != nil {
+		slog.DebugContext(ctx, "failed to send error event", "error", err)
+	}
+}
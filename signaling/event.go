@@ -0,0 +1,272 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type Event struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	// ID is optional and chosen by the client, the error event an event causes
+	// carries it as its request ID.
+	ID string `json:"id,omitempty"`
+}
+
+// EventHandler handles one incoming event of a client. Handlers are registered
+// per event type with Manager.HandleEvent.
+// ctx carries the log attributes of the client and the event, handlers pass it on to
+// the queries they make so those are logged with them.
+type EventHandler func(ctx context.Context, event Event, c *Client) error
+
+const (
+	EventSendMessage  = "send_message"
+	EventNewMessage   = "new_message"
+	EventChangeRoom   = "change_room"
+	EventJoinRoom     = "join_room"
+	EventRoomInfo     = "room_info"
+	EventNewPeer      = "new_peer"
+	EventUserJoin     = "user_join"
+	EventUserReady    = "user_ready"
+	EventPeerLeft     = "peer_left"
+	EventOffer        = "offer"
+	EventAnswer       = "answer"
+	EventIceCandidate = "ice_candidate"
+	EventLoadHistory  = "load_history"
+	EventRoomHistory  = "room_history"
+	EventError        = "error"
+	EventICEConfig    = "ice_config"
+	EventRenegotiate  = "renegotiate"
+	EventICERestart   = "ice_restart"
+
+	EventStartRecording   = "start_recording"
+	EventStopRecording    = "stop_recording"
+	EventRecordingStarted = "recording_started"
+	EventRecordingStopped = "recording_stopped"
+
+	EventServerShutdown = "server_shutdown"
+	EventSession        = "session"
+)
+
+// Error codes sent in an ErrorEvent.
+const (
+	// ErrCodeUnknownTarget means a signaling event addressed a session that is not in the sender's room.
+	ErrCodeUnknownTarget = "unknown_target"
+	// ErrCodeNotInRoom means a room scoped event was sent before joining a room.
+	ErrCodeNotInRoom = "not_in_room"
+	// ErrCodeNegotiating means an offer was sent to the SFU while its own offer is unanswered.
+	ErrCodeNegotiating = "negotiating"
+	// ErrCodeForbidden means the event is reserved to the room's owner.
+	ErrCodeForbidden = "forbidden"
+	// ErrCodeAlreadyRecording means start_recording was sent while the room is being recorded.
+	ErrCodeAlreadyRecording = "already_recording"
+	// ErrCodeNotRecording means stop_recording, or an event addressed to the recorder, was sent
+	// while the room is not being recorded.
+	ErrCodeNotRecording = "not_recording"
+	// ErrCodeBadRequest means the event is not valid JSON, or its payload does not fit its type.
+	ErrCodeBadRequest = "bad_request"
+	// ErrCodeUnknownEvent means no handler is registered for the event's type.
+	ErrCodeUnknownEvent = "unknown_event"
+	// ErrCodeRoomFull means the room to join has reached its capacity.
+	ErrCodeRoomFull = "room_full"
+	// ErrCodeNoPeerConnection means a signaling event addressed the SFU, or the recorder,
+	// while the server has no peer connection with the sender.
+	ErrCodeNoPeerConnection = "no_peer_connection"
+	// ErrCodeInternal means the server failed to handle the event, the details are only logged.
+	ErrCodeInternal = "internal"
+)
+
+type SendMessageEvent struct {
+	Message string `json:"message"`
+	From    string `json:"from"`
+}
+
+type NewMessageEvent struct {
+	SendMessageEvent
+	ID   int64     `json:"id"`
+	Sent time.Time `json:"sent"`
+}
+
+// LoadHistoryEvent asks for an older page of the current room's messages.
+// Before is the cursor returned by the previous room_history event.
+type LoadHistoryEvent struct {
+	Before int64 `json:"before"`
+	Limit  int   `json:"limit"`
+}
+
+// RoomHistoryEvent carries a page of persisted messages, oldest first.
+// Cursor is the value to send as Before to load the next older page, zero when there is none.
+type RoomHistoryEvent struct {
+	Room     string            `json:"room"`
+	Messages []NewMessageEvent `json:"messages"`
+	Cursor   int64             `json:"cursor"`
+}
+
+type ChangeRoomEvent struct {
+	Name string `json:"name"`
+	// Capacity and Mode only apply when the change creates the room.
+	Capacity int    `json:"capacity,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+}
+
+type JoinRoomEvent struct {
+	Room string `json:"room"`
+	// Capacity and Mode only apply when the join creates the room.
+	Capacity int    `json:"capacity,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+}
+
+// Peer identifies one websocket session of a user. SessionID is unique per connection
+// and is the value the From and To fields of signaling events refer to.
+type Peer struct {
+	SessionID string `json:"session_id"`
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+}
+
+// RoomMember is a session listed in room_info. Polite is the role the recipient takes
+// towards it in perfect negotiation: when both offer at once, the polite peer rolls
+// its own offer back and answers, the impolite one ignores the other offer.
+type RoomMember struct {
+	Peer
+	Polite bool `json:"polite"`
+}
+
+// RoomInfoEvent lists the sessions currently in a room, each recipient gets its own
+// roles. Mode tells clients whether to connect to each peer or to the SFU.
+type RoomInfoEvent struct {
+	Room  string       `json:"room"`
+	Mode  string       `json:"mode"`
+	Users []RoomMember `json:"users"`
+}
+
+// NewPeerEvent tells the members of a room that joined with join_room about a newcomer.
+// Polite is the role the recipient takes towards the newcomer.
+type NewPeerEvent struct {
+	Room string `json:"room"`
+	Peer
+	Polite bool `json:"polite"`
+}
+
+// UserJoinEvent tells the members of a room that joined with change_room about a newcomer.
+type UserJoinEvent struct {
+	Peer
+	Room     string    `json:"room"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// UserReadyEvent is sent by a client once its media is ready. The server forwards it
+// to the other ready members of the room with Offer set, telling them to send an
+// offer to the new peer, so that exactly one side of every pair makes the offer.
+type UserReadyEvent struct {
+	Peer
+	Room  string `json:"room"`
+	Offer bool   `json:"offer"`
+}
+
+// PeerLeftEvent tells the remaining members of a room that a session has left it,
+// so they can tear down the RTCPeerConnection to that peer.
+type PeerLeftEvent struct {
+	Room string `json:"room"`
+	Peer
+}
+
+// OfferEvent, AnswerEvent and IceCandidateEvent are relayed to the session in To.
+// From and FromUserID are always set by the server from the sender's session.
+type OfferEvent struct {
+	Room       string `json:"room"`
+	From       string `json:"from"`
+	FromUserID int64  `json:"from_user_id"`
+	To         string `json:"to"`
+	Sdp        string `json:"sdp"`
+}
+
+type AnswerEvent struct {
+	Room       string `json:"room"`
+	From       string `json:"from"`
+	FromUserID int64  `json:"from_user_id"`
+	To         string `json:"to"`
+	Sdp        string `json:"sdp"`
+}
+
+// IceCandidateEvent keeps the candidate as raw JSON, it is the browser's
+// RTCIceCandidateInit and only the receiving browser interprets it.
+type IceCandidateEvent struct {
+	Room       string          `json:"room"`
+	From       string          `json:"from"`
+	FromUserID int64           `json:"from_user_id"`
+	To         string          `json:"to"`
+	Candidate  json.RawMessage `json:"candidate"`
+}
+
+// RenegotiateEvent asks the peer To to send the sender a new offer, e.g. after the
+// sender added a screen share it wants the other side to negotiate.
+// Reason is free text for logs.
+type RenegotiateEvent struct {
+	Room       string `json:"room"`
+	From       string `json:"from"`
+	FromUserID int64  `json:"from_user_id"`
+	To         string `json:"to"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// ICERestartEvent asks the peer To to send the sender an offer that restarts ICE,
+// e.g. after the sender's network changed and the connection failed.
+type ICERestartEvent struct {
+	Room       string `json:"room"`
+	From       string `json:"from"`
+	FromUserID int64  `json:"from_user_id"`
+	To         string `json:"to"`
+}
+
+// SessionEvent is sent when a session starts and every time it is resumed. A client
+// whose websocket dropped reconnects to /ws?resume=<Token> within ResumeWithinMs to
+// get its session back, including its room and the events sent to it meanwhile.
+// Each token works once, the resumed session gets a new one.
+type SessionEvent struct {
+	SessionID      string `json:"session_id"`
+	Token          string `json:"token"`
+	ResumeWithinMs int64  `json:"resume_within_ms"`
+}
+
+// ServerShutdownEvent is sent to every client before the server closes its websocket
+// on shutdown. Clients should wait RetryAfterMs before reconnecting.
+type ServerShutdownEvent struct {
+	RetryAfterMs int64  `json:"retry_after_ms"`
+	Reason       string `json:"reason"`
+}
+
+// ErrorEvent tells a client that one of its events could not be handled. Event is the
+// type of that event and RequestID its ID, both are empty if it was not valid JSON.
+type ErrorEvent struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Event     string `json:"event,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ICEServer mirrors the browser's RTCIceServer, so the list in ice_config can be
+// passed to RTCPeerConnection as it is.
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// ICEConfigEvent is sent when a client connects and again whenever it sends ice_config.
+// ExpiresAt is when the TURN credentials in it stop working, nil if there are none,
+// clients should ask for a fresh config before then.
+type ICEConfigEvent struct {
+	ICEServers []ICEServer `json:"ice_servers"`
+	ExpiresAt  *time.Time  `json:"expires_at,omitempty"`
+}
+
+// RecordingEvent is broadcast to the room as recording_started and recording_stopped.
+// DurationMs is only set in recording_stopped.
+type RecordingEvent struct {
+	Room       string    `json:"room"`
+	ID         int64     `json:"id"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+}
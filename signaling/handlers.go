@@ -0,0 +1,519 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+func LoadHistoryHandler(ctx context.Context, event Event, c *Client) error {
+	var loadHistoryEvent LoadHistoryEvent
+	if err := decodePayload(event, &loadHistoryEvent); err != nil {
+		return err
+	}
+
+	limit := loadHistoryEvent.Limit
+	if limit <= 0 || limit > maxHistoryPageSize {
+		limit = historyPageSize
+	}
+
+	return c.manager.sendRoomHistory(ctx, c, loadHistoryEvent.Before, limit)
+}
+
+// sendRoomHistory loads a page of the client's current room from the database
+// and sends it to that client only.
+func (m *Manager) sendRoomHistory(ctx context.Context, c *Client, before int64, limit int) error {
+	room := c.Room()
+	if room == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	messages, err := m.messages.History(ctx, room.Name, before, limit)
+	if err != nil {
+		return fmt.Errorf("failed to load room history: %v", err)
+	}
+
+	historyEvent := RoomHistoryEvent{
+		Room:     room.Name,
+		Messages: make([]NewMessageEvent, 0, len(messages)),
+	}
+	for _, msg := range messages {
+		var newMessage NewMessageEvent
+		newMessage.ID = msg.ID
+		newMessage.Sent = msg.SentAt
+		newMessage.Message = msg.Body
+		newMessage.From = msg.Sender
+		historyEvent.Messages = append(historyEvent.Messages, newMessage)
+	}
+	// a full page means there may be older messages left to load.
+	if len(messages) == limit {
+		historyEvent.Cursor = messages[0].ID
+	}
+
+	data, err := json.Marshal(historyEvent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal room history: %v", err)
+	}
+
+	return c.Send(ctx, Event{
+		Type:    EventRoomHistory,
+		Payload: data,
+	})
+}
+
+func SendMessage(ctx context.Context, event Event, c *Client) error {
+	var chatevent SendMessageEvent
+	if err := decodePayload(event, &chatevent); err != nil {
+		return err
+	}
+
+	room := c.Room()
+	if room == nil {
+		return NewHandlerError(ErrCodeNotInRoom, "join a room before sending messages")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	// the sender is whoever the session was authenticated as, not what the payload claims
+	msg, err := c.manager.messages.Save(ctx, room.Name, c.Username, chatevent.Message)
+	if err != nil {
+		return fmt.Errorf("failed to persist message: %v", err)
+	}
+
+	var broadMessage NewMessageEvent
+
+	broadMessage.ID = msg.ID
+	broadMessage.Sent = msg.SentAt
+	broadMessage.Message = msg.Body
+	broadMessage.From = msg.Sender
+
+	data, err := json.Marshal(broadMessage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast message: %v", err)
+	}
+
+	c.manager.broadcast(ctx, room, nil, Event{
+		Payload: data,
+		Type:    EventNewMessage,
+	})
+
+	return nil
+}
+
+// ChatRoomHandler handles change_room, it moves the client into the room, replays
+// the room's history and announces the client with user_join.
+func ChatRoomHandler(ctx context.Context, event Event, c *Client) error {
+	var changeRoomEvent ChangeRoomEvent
+
+	if err := decodePayload(event, &changeRoomEvent); err != nil {
+		return err
+	}
+
+	return c.manager.joinAndAnnounce(ctx, c, changeRoomEvent.Name, RoomOptions{
+		Capacity: changeRoomEvent.Capacity,
+		Mode:     changeRoomEvent.Mode,
+	})
+}
+
+// UserJoinHandler handles user_join sent by a client, it behaves like change_room.
+func UserJoinHandler(ctx context.Context, event Event, c *Client) error {
+	var joinEvent UserJoinEvent
+	if err := decodePayload(event, &joinEvent); err != nil {
+		return err
+	}
+
+	return c.manager.joinAndAnnounce(ctx, c, joinEvent.Room, RoomOptions{})
+}
+
+// joinAndAnnounce moves c into the named room, replays the room's history to it
+// and tells the other members with a user_join event.
+func (m *Manager) joinAndAnnounce(ctx context.Context, c *Client, name string, options RoomOptions) error {
+	// Update client's room, leaving the old one
+	room, err := m.JoinRoom(c, name, options)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "changed room", "to", room.Name)
+
+	if err := m.sendRoomHistory(ctx, c, 0, historyPageSize); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(UserJoinEvent{
+		Peer:     c.Peer(),
+		Room:     room.Name,
+		JoinedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal join event: %v", err)
+	}
+
+	m.broadcast(ctx, room, c, Event{Type: EventUserJoin, Payload: data})
+
+	return nil
+}
+
+// JoinRoomHandler handles join_room, it moves the client into the room, sends the
+// member list to everyone in it and announces the client to the others with new_peer.
+// Each member is told its role towards the client, the members that joined first are impolite.
+func JoinRoomHandler(ctx context.Context, event Event, c *Client) error {
+	var joinRoomEvent JoinRoomEvent
+	if err := decodePayload(event, &joinRoomEvent); err != nil {
+		return err
+	}
+
+	// Update client's room
+	room, err := c.manager.JoinRoom(c, joinRoomEvent.Room, RoomOptions{
+		Capacity: joinRoomEvent.Capacity,
+		Mode:     joinRoomEvent.Mode,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.manager.sendRoomInfo(ctx, room); err != nil {
+		return err
+	}
+
+	members := c.manager.members(ctx, room)
+	joined := room.joinSeq(c.ID)
+	for _, other := range members {
+		if other.Peer.SessionID == c.ID {
+			continue
+		}
+
+		newPeerData, err := json.Marshal(NewPeerEvent{
+			Room:   room.Name,
+			Peer:   c.Peer(),
+			Polite: other.seq > joined,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal new peer event: %v", err)
+		}
+
+		if err := c.manager.sendTo(ctx, other.Peer.SessionID, Event{Type: EventNewPeer, Payload: newPeerData}); err != nil {
+			return err
+		}
+	}
+
+	return c.manager.sendRoomHistory(ctx, c, 0, historyPageSize)
+}
+
+// UserReadyHandler handles user_ready, it asks the members that were ready first
+// to send the client an offer.
+func UserReadyHandler(ctx context.Context, event Event, c *Client) error {
+	var userReadyEvent UserReadyEvent
+	if err := decodePayload(event, &userReadyEvent); err != nil {
+		return err
+	}
+
+	room := c.Room()
+	if room == nil {
+		return NewHandlerError(ErrCodeNotInRoom, "join a room before sending user_ready")
+	}
+
+	// in an SFU room everyone is connected to the server already
+	if room.sfu != nil {
+		return nil
+	}
+
+	// peers that were ready first make the offer, the newcomer only answers
+	ready := room.markReady(c)
+	if len(ready) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(UserReadyEvent{
+		Peer:  c.Peer(),
+		Room:  room.Name,
+		Offer: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal user ready event: %v", err)
+	}
+
+	for _, peer := range ready {
+		if err := c.manager.sendTo(ctx, peer.ID, Event{Type: EventUserReady, Payload: data}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func OfferHandler(ctx context.Context, event Event, c *Client) error {
+	var offerEvent OfferEvent
+	if err := decodePayload(event, &offerEvent); err != nil {
+		return err
+	}
+
+	room := c.Room()
+	if room == nil {
+		return NewHandlerError(ErrCodeNotInRoom, "join a room before sending offer")
+	}
+
+	if room.sfu != nil {
+		return room.sfu.handleOffer(c, offerEvent.Sdp)
+	}
+
+	offerEvent.Room = room.Name
+	offerEvent.From = c.ID
+	offerEvent.FromUserID = c.UserID
+
+	return c.RelayToPeer(ctx, room, offerEvent.To, EventOffer, offerEvent)
+}
+
+func AnswerHandler(ctx context.Context, event Event, c *Client) error {
+	var answerEvent AnswerEvent
+	if err := decodePayload(event, &answerEvent); err != nil {
+		return err
+	}
+
+	room := c.Room()
+	if room == nil {
+		return NewHandlerError(ErrCodeNotInRoom, "join a room before sending answer")
+	}
+
+	if answerEvent.To == RecorderPeerID {
+		if rec := room.activeRecording(); rec != nil {
+			return rec.handleAnswer(c, answerEvent.Sdp)
+		}
+		return NewHandlerError(ErrCodeNotRecording, "the room is not being recorded")
+	}
+
+	if room.sfu != nil {
+		return room.sfu.handleAnswer(c, answerEvent.Sdp)
+	}
+
+	answerEvent.Room = room.Name
+	answerEvent.From = c.ID
+	answerEvent.FromUserID = c.UserID
+
+	return c.RelayToPeer(ctx, room, answerEvent.To, EventAnswer, answerEvent)
+}
+
+func IceCandidateHandler(ctx context.Context, event Event, c *Client) error {
+	var iceCandidateEvent IceCandidateEvent
+	if err := decodePayload(event, &iceCandidateEvent); err != nil {
+		return err
+	}
+
+	room := c.Room()
+	if room == nil {
+		return NewHandlerError(ErrCodeNotInRoom, "join a room before sending ice_candidate")
+	}
+
+	if iceCandidateEvent.To == RecorderPeerID {
+		if rec := room.activeRecording(); rec != nil {
+			return rec.handleCandidate(c, iceCandidateEvent.Candidate)
+		}
+		return NewHandlerError(ErrCodeNotRecording, "the room is not being recorded")
+	}
+
+	if room.sfu != nil {
+		return room.sfu.handleCandidate(c, iceCandidateEvent.Candidate)
+	}
+
+	iceCandidateEvent.Room = room.Name
+	iceCandidateEvent.From = c.ID
+	iceCandidateEvent.FromUserID = c.UserID
+
+	return c.RelayToPeer(ctx, room, iceCandidateEvent.To, EventIceCandidate, iceCandidateEvent)
+}
+
+// StartRecordingHandler handles start_recording, it lets the room's owner start
+// recording the room. Every member is told with recording_started and then gets an
+// offer from the recorder.
+func StartRecordingHandler(ctx context.Context, event Event, c *Client) error {
+	room := c.Room()
+	if room == nil {
+		return NewHandlerError(ErrCodeNotInRoom, "join a room before sending start_recording")
+	}
+
+	if room.Owner != c.UserID {
+		return NewHandlerError(ErrCodeForbidden, "only the room's owner can start recording")
+	}
+
+	if room.activeRecording() != nil {
+		return NewHandlerError(ErrCodeAlreadyRecording, "the room is already being recorded")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rec, err := c.manager.startRecording(ctx, room, c)
+	if err != nil {
+		return fmt.Errorf("failed to start recording: %v", err)
+	}
+
+	if !room.setRecording(rec) {
+		// another start_recording won the race, drop this one
+		if _, err := rec.stop(); err != nil {
+			slog.ErrorContext(ctx, "failed to stop duplicate recording", "recording", rec.ID, "error", err)
+		}
+		return NewHandlerError(ErrCodeAlreadyRecording, "the room is already being recorded")
+	}
+
+	slog.InfoContext(ctx, "started recording", "recording", rec.ID)
+
+	data, err := json.Marshal(RecordingEvent{
+		Room:      room.Name,
+		ID:        rec.ID,
+		StartedAt: rec.StartedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal recording started event: %v", err)
+	}
+
+	c.manager.broadcast(ctx, room, nil, Event{Type: EventRecordingStarted, Payload: data})
+
+	for _, member := range room.Clients() {
+		if err := rec.addPeer(member); err != nil {
+			slog.ErrorContext(ctx, "failed to connect member to recording", "recording", rec.ID, "member", member.ID, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// StopRecordingHandler handles stop_recording, it lets the room's owner stop the
+// running recording. Once every file is written the members are told with
+// recording_stopped.
+func StopRecordingHandler(ctx context.Context, event Event, c *Client) error {
+	room := c.Room()
+	if room == nil {
+		return NewHandlerError(ErrCodeNotInRoom, "join a room before sending stop_recording")
+	}
+
+	if room.Owner != c.UserID {
+		return NewHandlerError(ErrCodeForbidden, "only the room's owner can stop recording")
+	}
+
+	rec := room.takeRecording()
+	if rec == nil {
+		return NewHandlerError(ErrCodeNotRecording, "the room is not being recorded")
+	}
+
+	duration, err := rec.stop()
+	if err != nil {
+		return fmt.Errorf("failed to stop recording: %v", err)
+	}
+
+	slog.InfoContext(ctx, "stopped recording", "recording", rec.ID, "duration", duration)
+
+	data, err := json.Marshal(RecordingEvent{
+		Room:       room.Name,
+		ID:         rec.ID,
+		StartedAt:  rec.StartedAt,
+		DurationMs: duration.Milliseconds(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal recording stopped event: %v", err)
+	}
+
+	c.manager.broadcast(ctx, room, nil, Event{Type: EventRecordingStopped, Payload: data})
+
+	return nil
+}
+
+// RenegotiateHandler handles renegotiate, it asks the addressed peer for a new offer.
+// Addressed to the SFU, the server sends that offer itself.
+func RenegotiateHandler(ctx context.Context, event Event, c *Client) error {
+	var renegotiateEvent RenegotiateEvent
+	if err := decodePayload(event, &renegotiateEvent); err != nil {
+		return err
+	}
+
+	room := c.Room()
+	if room == nil {
+		return NewHandlerError(ErrCodeNotInRoom, "join a room before sending renegotiate")
+	}
+
+	// the recorder only receives, it has nothing to renegotiate
+	if renegotiateEvent.To == RecorderPeerID {
+		return nil
+	}
+
+	if room.sfu != nil {
+		return room.sfu.renegotiate(c, false)
+	}
+
+	renegotiateEvent.Room = room.Name
+	renegotiateEvent.From = c.ID
+	renegotiateEvent.FromUserID = c.UserID
+
+	return c.RelayToPeer(ctx, room, renegotiateEvent.To, EventRenegotiate, renegotiateEvent)
+}
+
+// ICERestartHandler handles ice_restart, it asks the addressed peer for an offer that
+// restarts ICE. Addressed to the SFU, the server sends that offer itself.
+func ICERestartHandler(ctx context.Context, event Event, c *Client) error {
+	var iceRestartEvent ICERestartEvent
+	if err := decodePayload(event, &iceRestartEvent); err != nil {
+		return err
+	}
+
+	room := c.Room()
+	if room == nil {
+		return NewHandlerError(ErrCodeNotInRoom, "join a room before sending ice_restart")
+	}
+
+	if iceRestartEvent.To == RecorderPeerID {
+		if rec := room.activeRecording(); rec != nil {
+			return rec.addPeer(c)
+		}
+		return NewHandlerError(ErrCodeNotRecording, "the room is not being recorded")
+	}
+
+	if room.sfu != nil {
+		return room.sfu.renegotiate(c, true)
+	}
+
+	iceRestartEvent.Room = room.Name
+	iceRestartEvent.From = c.ID
+	iceRestartEvent.FromUserID = c.UserID
+
+	return c.RelayToPeer(ctx, room, iceRestartEvent.To, EventICERestart, iceRestartEvent)
+}
+
+// ICEConfigHandler answers ice_config with a fresh ICE server list, clients send it
+// to renew their TURN credentials before they expire.
+func ICEConfigHandler(ctx context.Context, event Event, c *Client) error {
+	return c.manager.sendICEConfig(ctx, c)
+}
+
+// sendICEConfig sends c the configured STUN servers and the TURN servers with
+// credentials minted for c's user.
+func (m *Manager) sendICEConfig(ctx context.Context, c *Client) error {
+	iceConfigEvent := ICEConfigEvent{
+		ICEServers: make([]ICEServer, 0, 2),
+	}
+
+	if len(m.ice.STUN_URLS) > 0 {
+		iceConfigEvent.ICEServers = append(iceConfigEvent.ICEServers, ICEServer{URLs: m.ice.STUN_URLS})
+	}
+
+	if len(m.ice.TURN_URLS) > 0 && m.ice.TURN_SECRET != "" {
+		username, password, expiresAt := TURNCredentials(m.ice.TURN_SECRET, c.Username, m.ice.CREDENTIAL_TTL)
+		iceConfigEvent.ICEServers = append(iceConfigEvent.ICEServers, ICEServer{
+			URLs:       m.ice.TURN_URLS,
+			Username:   username,
+			Credential: password,
+		})
+		iceConfigEvent.ExpiresAt = &expiresAt
+	}
+
+	data, err := json.Marshal(iceConfigEvent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ice config event: %v", err)
+	}
+
+	return c.Send(ctx, Event{Type: EventICEConfig, Payload: data})
+}
@@ -0,0 +1,27 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRecorderEventsWhileNotRecording(t *testing.T) {
+	c := newTestClient(t, nil)
+	if _, err := c.manager.JoinRoom(c, "lobby", RoomOptions{}); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+
+	for _, event := range []Event{
+		{Type: EventAnswer, Payload: []byte(`{"to":"recorder","sdp":""}`)},
+		{Type: EventIceCandidate, Payload: []byte(`{"to":"recorder","candidate":{}}`)},
+		{Type: EventICERestart, Payload: []byte(`{"to":"recorder"}`)},
+	} {
+		err := c.manager.routeEvent(context.Background(), event, c)
+
+		var handlerErr *HandlerError
+		if !errors.As(err, &handlerErr) || handlerErr.Code != ErrCodeNotRecording {
+			t.Errorf("%s to the recorder = %v, want %s", event.Type, err, ErrCodeNotRecording)
+		}
+	}
+}
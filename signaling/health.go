@@ -0,0 +1,78 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+// readyTimeout bounds the dependency checks of a single readiness probe.
+const readyTimeout = 2 * time.Second
+
+// ReadyStatus is the body of /readyz. Each check is "ok" or "fail", why a check failed
+// is only logged, the probe may be reachable from outside.
+type ReadyStatus struct {
+	Ready        bool              `json:"ready"`
+	ShuttingDown bool              `json:"shutting_down"`
+	Checks       map[string]string `json:"checks"`
+	Clients      int               `json:"clients"`
+	Rooms        int               `json:"rooms"`
+}
+
+// HealthzHandler is the liveness probe, it answers 200 as long as the process serves HTTP.
+func (m *Manager) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// ReadyzHandler is the readiness probe. It pings the database and the OTP store and
+// reports the connected client and room counts. It answers 503 when a check fails
+// and once Shutdown was called, so no new sessions are routed here while draining.
+func (m *Manager) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	status := ReadyStatus{Ready: true, Checks: make(map[string]string)}
+
+	check := func(name string, err error) {
+		if err != nil {
+			slog.WarnContext(ctx, "readiness check failed", "check", name, "error", err)
+			status.Ready = false
+			status.Checks[name] = "fail"
+			return
+		}
+		status.Checks[name] = "ok"
+	}
+	check("database", db.Ping(ctx, m.pool))
+	check("otp_store", m.otps.Ping(ctx))
+
+	m.RLock()
+	status.ShuttingDown = m.shuttingDown
+	status.Clients = len(m.clients)
+	status.Rooms = len(m.rooms)
+	m.RUnlock()
+
+	if status.ShuttingDown {
+		status.Ready = false
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to marshal ready status", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(data)
+}
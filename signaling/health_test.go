@@ -0,0 +1,51 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zenk41/learn-webrtc/signaling/config"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+// pingPool is a database pool that only answers pings, with err.
+type pingPool struct {
+	db.PgxPool
+	err error
+}
+
+func (p pingPool) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestReadyzHidesCheckErrors(t *testing.T) {
+	const secret = "password authentication failed for user \"signaling\""
+	otps := NewMemoryOTPStore(context.Background(), time.Minute)
+	defer otps.Close()
+
+	m := NewManager(pingPool{err: errors.New(secret)}, otps, config.ICEConfig{}, config.RecordingConfig{}, config.ServerConfig{})
+
+	rec := httptest.NewRecorder()
+	m.ReadyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if strings.Contains(rec.Body.String(), secret) {
+		t.Fatalf("body leaks the check's error: %s", rec.Body)
+	}
+
+	var status ReadyStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if status.Checks["database"] != "fail" || status.Checks["otp_store"] != "ok" {
+		t.Fatalf("checks = %v, want the database failed and the otp store ok", status.Checks)
+	}
+}
@@ -0,0 +1,94 @@
+package signaling
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+)
+
+// requestIDHeader carries the correlation ID of an HTTP request. One sent by a proxy
+// is kept, otherwise a new one is generated, either way it is echoed in the response.
+const requestIDHeader = "X-Request-ID"
+
+// logAttrsKey is the context key of the attributes added with WithLogAttrs.
+type logAttrsKey struct{}
+
+// WithLogAttrs returns a copy of ctx that carries attrs in addition to the attributes
+// ctx carries already. Every record logged with the context, or a context derived from
+// it, through a handler from NewLogHandler gets them. That includes the queries the pgx
+// tracer logs, so a query can be traced back to the session and event that made it.
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	parent, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+
+	merged := make([]slog.Attr, 0, len(parent)+len(attrs))
+	merged = append(merged, parent...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, logAttrsKey{}, merged)
+}
+
+// NewLogHandler returns the text or JSON handler selected by cfg writing to w.
+// It adds the attributes of WithLogAttrs to every record.
+func NewLogHandler(cfg config.LogConfig, w io.Writer) slog.Handler {
+	opts := &slog.HandlerOptions{Level: cfg.LEVEL}
+
+	if cfg.FORMAT == config.LogFormatJSON {
+		return contextHandler{slog.NewJSONHandler(w, opts)}
+	}
+	return contextHandler{slog.NewTextHandler(w, opts)}
+}
+
+// contextHandler adds the attributes carried by the context to the records it handles.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// requestContext returns the context of r carrying its correlation ID as request_id,
+// and sets the ID on the response.
+func requestContext(w http.ResponseWriter, r *http.Request) context.Context {
+	requestID := r.Header.Get(requestIDHeader)
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	w.Header().Set(requestIDHeader, requestID)
+
+	return WithLogAttrs(r.Context(), slog.String("request_id", requestID))
+}
+
+// logContext returns ctx carrying the client's session ID and username.
+func (c *Client) logContext(ctx context.Context) context.Context {
+	return WithLogAttrs(ctx, slog.String("client_id", c.ID), slog.String("username", c.Username))
+}
+
+// logger returns the default logger with the client's session ID and username, for
+// logging outside of the client's own goroutines.
+func (c *Client) logger() *slog.Logger {
+	return slog.With("client_id", c.ID, "username", c.Username)
+}
+
+// eventContext returns ctx carrying the event type and the room the client is in.
+func (c *Client) eventContext(ctx context.Context, eventType string) context.Context {
+	attrs := []slog.Attr{slog.String("event", eventType)}
+	if room := c.Room(); room != nil {
+		attrs = append(attrs, slog.String("room", room.Name))
+	}
+	return WithLogAttrs(ctx, attrs...)
+}
@@ -0,0 +1,104 @@
+package signaling
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// recordHandler hands every record it handles to records.
+type recordHandler struct {
+	records chan slog.Record
+}
+
+func (h recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h recordHandler) Handle(_ context.Context, record slog.Record) error {
+	select {
+	case h.records <- record.Clone():
+	default:
+	}
+	return nil
+}
+
+func (h recordHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h recordHandler) WithGroup(string) slog.Handler { return h }
+
+// captureLogs makes the default logger add the context's attributes and hand the
+// records to the returned channel until the test ends.
+func captureLogs(t *testing.T) <-chan slog.Record {
+	t.Helper()
+
+	records := make(chan slog.Record, 256)
+	previous := slog.Default()
+	slog.SetDefault(slog.New(contextHandler{recordHandler{records}}))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return records
+}
+
+// waitRecord returns the first record logged with message.
+func waitRecord(t *testing.T, records <-chan slog.Record, message string) slog.Record {
+	t.Helper()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case record := <-records:
+			if record.Message == message {
+				return record
+			}
+		case <-deadline:
+			t.Fatalf("nothing logged %q", message)
+		}
+	}
+}
+
+// countAttrs counts the attributes of record by key.
+func countAttrs(record slog.Record) map[string]int {
+	counts := map[string]int{}
+	record.Attrs(func(attr slog.Attr) bool {
+		counts[attr.Key]++
+		return true
+	})
+	return counts
+}
+
+func TestResumedWebsocketLogsClientAttrsOnce(t *testing.T) {
+	conn := testWebsocket(t)
+	c := newTestClient(t, conn)
+	m := c.manager
+	m.server.ALLOWED_ORIGINS = []string{"*"}
+	m.addClient(c)
+	m.dropClient(c, conn, true)
+
+	m.RLock()
+	token := c.token
+	m.RUnlock()
+
+	records := captureLogs(t)
+	srv := httptest.NewServer(http.HandlerFunc(m.ServeWS))
+	t.Cleanup(srv.Close)
+	resumed, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?resume="+token,
+		http.Header{"Origin": {"https://example.com"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { resumed.Close() })
+
+	// one line of the request handler, one of the write goroutine
+	for _, message := range []string{"session resumed", "event sent"} {
+		counts := countAttrs(waitRecord(t, records, message))
+		for _, key := range []string{"request_id", "client_id", "username"} {
+			if counts[key] != 1 {
+				t.Fatalf("%q carries %s %d times, want once", message, key, counts[key])
+			}
+		}
+	}
+}
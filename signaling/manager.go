@@ -0,0 +1,645 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+const (
+	// historyPageSize is the number of messages replayed on join and the default page size.
+	historyPageSize = 50
+	// maxHistoryPageSize caps the page size a client may ask for with load_history.
+	maxHistoryPageSize = 200
+	// dbTimeout bounds every database call made while handling an event.
+	dbTimeout = 5 * time.Second
+	// writeWait bounds every websocket write, a client that stopped reading fails it.
+	writeWait = 10 * time.Second
+	// drainInterval is how often Shutdown checks whether every client is gone.
+	drainInterval = 50 * time.Millisecond
+	// resumeGrace is how long a session whose websocket dropped waits to be resumed
+	// before its client is removed and its room told it left.
+	resumeGrace = 30 * time.Second
+)
+
+// Manager owns the connected clients and their rooms and routes every incoming
+// event to the handler registered for its type.
+type Manager struct {
+	clients ClientList
+	// rooms indexes the active rooms by name, guarded by the same lock as clients
+	rooms map[string]*Room
+	// sessions indexes the clients by their resume token, guarded by the same lock
+	sessions map[string]*Client
+	sync.RWMutex
+	// shuttingDown refuses new websocket upgrades once Shutdown was called
+	shuttingDown bool
+	// retryAfter is the reconnect hint given to clients refused during shutdown
+	retryAfter time.Duration
+	// background tracks work that outlives the client that started it, e.g.
+	// stopping the recording of a room whose last member left
+	background sync.WaitGroup
+
+	// server holds the websocket settings, upgrader checks origins against it
+	server   config.ServerConfig
+	upgrader websocket.Upgrader
+
+	otps OTPStore
+	// ice is the ICE server list sent to clients in ice_config
+	ice config.ICEConfig
+
+	handlers map[string]EventHandler
+
+	// bus routes every fan-out to the subscribed rooms and sessions, cluster lists the
+	// members of the rooms shared with other instances. StartCluster replaces the
+	// in-memory bus and sets cluster before the manager serves websockets.
+	bus     Bus
+	cluster *Cluster
+
+	// pool is pinged by the readiness probe
+	pool       db.PgxPool
+	metrics    *metrics
+	messages   *db.MessageRepository
+	users      *db.UserRepository
+	recordings *db.RecordingRepository
+	// recordingDir is where room recordings are written
+	recordingDir string
+}
+
+// NewManager returns a Manager with the built-in chat and signaling handlers registered.
+// pool backs users and message history, otps authenticates the websocket upgrade and
+// ice lists the STUN and TURN servers handed to clients, recording says where
+// room recordings are written and server holds the websocket settings.
+func NewManager(pool db.PgxPool, otps OTPStore, ice config.ICEConfig, recording config.RecordingConfig,
+	server config.ServerConfig) *Manager {
+	m := &Manager{clients: make(ClientList), rooms: make(map[string]*Room), sessions: make(map[string]*Client),
+		handlers: make(map[string]EventHandler), bus: NewMemoryBus(), otps: otps, ice: ice, server: server, pool: pool,
+		messages: db.NewMessageRepository(pool), users: db.NewUserRepository(pool),
+		recordings: db.NewRecordingRepository(pool), recordingDir: recording.DIR}
+	m.upgrader = websocket.Upgrader{
+		CheckOrigin:     m.checkOrigin,
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+	}
+	m.metrics = newMetrics(m, pool)
+	m.setupEventHandlers()
+	return m
+}
+
+func (m *Manager) setupEventHandlers() {
+	m.handlers[EventSendMessage] = SendMessage
+	m.handlers[EventChangeRoom] = ChatRoomHandler
+	m.handlers[EventJoinRoom] = JoinRoomHandler
+	m.handlers[EventUserJoin] = UserJoinHandler
+	m.handlers[EventUserReady] = UserReadyHandler
+	m.handlers[EventOffer] = OfferHandler
+	m.handlers[EventAnswer] = AnswerHandler
+	m.handlers[EventIceCandidate] = IceCandidateHandler
+	m.handlers[EventLoadHistory] = LoadHistoryHandler
+	m.handlers[EventICEConfig] = ICEConfigHandler
+	m.handlers[EventRenegotiate] = RenegotiateHandler
+	m.handlers[EventICERestart] = ICERestartHandler
+	m.handlers[EventStartRecording] = StartRecordingHandler
+	m.handlers[EventStopRecording] = StopRecordingHandler
+}
+
+// HandleEvent registers handler for events of eventType, replacing the built-in
+// handler if there is one. Handlers should be registered before ServeWS is used,
+// the handler table is not guarded against concurrent changes.
+func (m *Manager) HandleEvent(eventType string, handler EventHandler) {
+	m.handlers[eventType] = handler
+}
+
+// routeEvent handles event with the handler registered for its type. ctx carries the
+// log attributes of the client and the event, the handler passes it on to its queries.
+func (m *Manager) routeEvent(ctx context.Context, event Event, c *Client) error {
+	// check if the event type is part of the handlers
+	if handler, ok := m.handlers[event.Type]; ok {
+		m.metrics.eventsReceived.WithLabelValues(event.Type).Inc()
+
+		startedAt := time.Now()
+		err := handler(ctx, event, c)
+		m.metrics.observeHandler(event.Type, startedAt, err)
+		if err != nil {
+			return err
+		}
+		return nil
+	} else {
+		m.metrics.eventsReceived.WithLabelValues(unknownEventType).Inc()
+		return NewHandlerError(ErrCodeUnknownEvent, fmt.Sprintf("there is no such event type %q", event.Type))
+	}
+}
+
+// ServeWS authenticates the request with the otp query parameter and upgrades it
+// to a websocket client session. A request with the resume query parameter instead
+// resumes the session that token was issued for, see resumeSession.
+func (m *Manager) ServeWS(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(w, r)
+
+	m.RLock()
+	shuttingDown, retryAfter := m.shuttingDown, m.retryAfter
+	m.RUnlock()
+	if shuttingDown {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Round(time.Second)/time.Second)))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if token := r.URL.Query().Get("resume"); token != "" {
+		m.resumeWS(ctx, w, r, token)
+		return
+	}
+
+	otp := r.URL.Query().Get("otp")
+	if otp == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	verified, err := m.otps.VerifyOTP(ctx, otp)
+	if errors.Is(err, ErrInvalidOTP) {
+		m.metrics.otpVerification.WithLabelValues(resultInvalid).Inc()
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		m.metrics.otpVerification.WithLabelValues(resultError).Inc()
+		slog.ErrorContext(ctx, "failed to verify otp", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	m.metrics.otpVerification.WithLabelValues(resultSuccess).Inc()
+
+	// upgrade regular http connection into websocket
+	conn, err := m.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to upgrade websocket", "error", err)
+		return
+	}
+	client := NewClient(conn, m, verified.Claims, uuid.NewString())
+
+	ctx = client.logContext(ctx)
+	slog.InfoContext(ctx, "new connection")
+
+	m.addClient(client)
+
+	// Start goroutine client processes, the websocket outlives the request so its
+	// context must not be cancelled with it
+	client.serve(context.WithoutCancel(ctx), conn, client.stop)
+
+	if err := m.sendSession(ctx, client); err != nil {
+		slog.ErrorContext(ctx, "failed to send session", "error", err)
+	}
+	if err := m.sendICEConfig(ctx, client); err != nil {
+		slog.ErrorContext(ctx, "failed to send ice config", "error", err)
+	}
+}
+
+func (m *Manager) addClient(client *Client) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.clients[client] = true
+	client.token = newSessionToken()
+	m.sessions[client.token] = client
+
+	unsubscribe, err := m.bus.Subscribe(ClientTopic(client.ID), func(msg BusMessage) {
+		client.deliver(msg.Event)
+	})
+	if err != nil {
+		client.logger().Error("failed to subscribe to the session's events", "error", err)
+		return
+	}
+	client.unsubscribe = unsubscribe
+}
+
+func (m *Manager) removeClient(client *Client) {
+	m.Lock()
+	if _, ok := m.clients[client]; !ok {
+		m.Unlock()
+		return
+	}
+	client.close(websocket.CloseNormalClosure, "session ended")
+	delete(m.clients, client)
+	delete(m.sessions, client.token)
+	if client.expire != nil {
+		client.expire.Stop()
+	}
+	if client.unsubscribe != nil {
+		client.unsubscribe()
+	}
+
+	room := client.room
+	if room != nil {
+		m.leaveRoomLocked(client, room)
+	}
+	m.Unlock()
+
+	stats := client.egress.stats()
+	m.metrics.clientDrops.Observe(float64(stats.Dropped))
+	client.logger().Info("session ended", "dropped", stats.Dropped)
+
+	// announce outside the lock, publishing may reach the database
+	if room != nil {
+		m.announcePeerLeft(client, room)
+	}
+}
+
+// JoinRoom moves c into the named room. The room is created on demand with c's user
+// as owner and the given options, and the room c was in before is left first.
+// In an SFU room the server then offers c its peer connection, and while the room
+// is being recorded the recorder offers c one as well.
+func (m *Manager) JoinRoom(c *Client, name string, options RoomOptions) (*Room, error) {
+	if name == "" {
+		return nil, NewHandlerError(ErrCodeBadRequest, "room name is required")
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	m.Lock()
+	old := c.room
+	if old != nil && old.Name == name {
+		m.Unlock()
+		return old, nil
+	}
+
+	room, ok := m.rooms[name]
+	if !ok {
+		room = newRoom(name, c.UserID, options)
+		if room.Mode == RoomModeSFU {
+			room.sfu = newSFU(room.Name, m.webrtcConfig())
+		}
+		m.rooms[name] = room
+		m.subscribeRoom(room)
+	}
+	if err := room.add(c); err != nil {
+		m.Unlock()
+		return nil, fmt.Errorf("cannot join room %s: %w", name, err)
+	}
+
+	if old != nil {
+		m.leaveRoomLocked(c, old)
+	}
+	c.room = room
+	m.Unlock()
+
+	if old != nil {
+		m.announcePeerLeft(c, old)
+	}
+
+	if m.cluster != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		seq, err := m.cluster.join(ctx, c, room.Name)
+		cancel()
+		if err != nil {
+			// the members on this node still see c, the other nodes will not
+			c.logger().Error("failed to register room member in the cluster", "room", room.Name, "error", err)
+		} else {
+			room.setJoinSeq(c.ID, uint64(seq))
+		}
+	}
+
+	if room.sfu != nil {
+		if err := room.sfu.addPeer(c); err != nil {
+			return room, fmt.Errorf("failed to connect to the sfu of room %s: %w", room.Name, err)
+		}
+	}
+
+	if rec := room.activeRecording(); rec != nil {
+		if err := rec.addPeer(c); err != nil {
+			return room, fmt.Errorf("failed to connect to the recorder of room %s: %w", room.Name, err)
+		}
+	}
+
+	return room, nil
+}
+
+// leaveRoomLocked removes c from room and reaps the room once it is empty, a
+// recording still running in it is stopped in the background.
+// The caller must hold the manager's lock.
+func (m *Manager) leaveRoomLocked(c *Client, room *Room) {
+	if room.sfu != nil {
+		room.sfu.removePeer(c)
+	}
+	if rec := room.activeRecording(); rec != nil {
+		rec.removePeer(c)
+	}
+	if room.remove(c) == 0 && m.rooms[room.Name] == room {
+		delete(m.rooms, room.Name)
+		if room.unsubscribe != nil {
+			room.unsubscribe()
+		}
+		if room.sfu != nil {
+			room.sfu.close()
+		}
+		if rec := room.takeRecording(); rec != nil {
+			m.background.Add(1)
+			go func() {
+				defer m.background.Done()
+				if _, err := rec.stop(); err != nil {
+					slog.Error("failed to stop recording", "recording", rec.ID, "room", room.Name, "error", err)
+				}
+			}()
+		}
+	}
+	if c.room == room {
+		c.room = nil
+	}
+}
+
+// Shutdown refuses new websocket upgrades, sends every client server_shutdown with
+// retryAfter as the reconnect hint and closes its websocket with 1012 (service restart).
+// It returns once every client is gone and the recordings they left running are
+// stopped, or when ctx is done, dropping the clients still connected then.
+func (m *Manager) Shutdown(ctx context.Context, retryAfter time.Duration) error {
+	m.Lock()
+	m.shuttingDown = true
+	m.retryAfter = retryAfter
+	clients := make([]*Client, 0, len(m.clients))
+	for client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.Unlock()
+
+	data, err := json.Marshal(ServerShutdownEvent{
+		RetryAfterMs: retryAfter.Milliseconds(),
+		Reason:       "server is shutting down",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal server shutdown event: %v", err)
+	}
+
+	Deliver(clients, Event{Type: EventServerShutdown, Payload: data})
+	for _, client := range clients {
+		// a detached session has no websocket to close, nobody will resume it here
+		if client.detached.Load() {
+			m.removeClient(client)
+			continue
+		}
+		client.close(websocket.CloseServiceRestart, "server is shutting down")
+	}
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for {
+		m.RLock()
+		remaining := len(m.clients)
+		m.RUnlock()
+		if remaining == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			m.RLock()
+			clients = clients[:0]
+			for client := range m.clients {
+				clients = append(clients, client)
+			}
+			m.RUnlock()
+
+			for _, client := range clients {
+				m.removeClient(client)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		m.background.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// announcePeerLeft tells the members still in room that client is gone, followed by
+// the room's updated member list. It is used both when a client disconnects, including
+// a ping/pong timeout, and when it moves to another room.
+func (m *Manager) announcePeerLeft(client *Client, room *Room) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if m.cluster != nil {
+		if err := m.cluster.leave(ctx, client, room.Name); err != nil {
+			client.logger().Error("failed to remove room member from the cluster", "room", room.Name, "error", err)
+		}
+	}
+
+	peerLeftData, err := json.Marshal(PeerLeftEvent{
+		Room: room.Name,
+		Peer: client.Peer(),
+	})
+	if err != nil {
+		slog.Error("failed to marshal peer left event", "room", room.Name, "error", err)
+		return
+	}
+
+	m.broadcast(ctx, room, nil, Event{Type: EventPeerLeft, Payload: peerLeftData})
+
+	if err := m.sendRoomInfo(ctx, room); err != nil {
+		slog.Error("failed to send room info", "room", room.Name, "error", err)
+	}
+}
+
+// roomMember is a member of a room, connected to this node or, in cluster mode, to another one.
+type roomMember struct {
+	Peer Peer
+	// seq orders the members by when they joined, the later member is the polite one
+	seq uint64
+	// client is set for members connected to this node
+	client *Client
+}
+
+// members returns the members of room in the order they joined. In cluster mode that
+// includes the members on the other nodes, if they cannot be loaded only the local
+// members are returned.
+func (m *Manager) members(ctx context.Context, room *Room) []roomMember {
+	local := room.Clients()
+
+	members := make([]roomMember, 0, len(local))
+	listed := make(map[string]bool, len(local))
+
+	if m.cluster != nil {
+		rows, err := m.cluster.members(ctx, room.Name)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to load cluster room members", "room", room.Name, "error", err)
+		}
+		for _, row := range rows {
+			member := roomMember{
+				Peer: Peer{SessionID: row.SessionID, UserID: row.UserID, Username: row.Username},
+				seq:  uint64(row.Seq),
+			}
+			if row.NodeID == m.cluster.NodeID {
+				// the member may have left this node since, then it is not listed
+				if member.client = room.Client(row.SessionID); member.client == nil {
+					continue
+				}
+			}
+			members = append(members, member)
+			listed[row.SessionID] = true
+		}
+	}
+
+	for _, client := range local {
+		if !listed[client.ID] {
+			members = append(members, roomMember{Peer: client.Peer(), seq: room.joinSeq(client.ID), client: client})
+		}
+	}
+
+	sort.SliceStable(members, func(i, j int) bool { return members[i].seq < members[j].seq })
+	return members
+}
+
+// subscribeRoom delivers the events published to room's topic to its members on this
+// node, until the room is reaped. Call it with the lock held.
+func (m *Manager) subscribeRoom(room *Room) {
+	unsubscribe, err := m.bus.Subscribe(RoomTopic(room.Name), func(msg BusMessage) {
+		recipients := room.Clients()
+		for i, client := range recipients {
+			if client.ID == msg.Exclude {
+				recipients = append(recipients[:i], recipients[i+1:]...)
+				break
+			}
+		}
+		Deliver(recipients, msg.Event)
+	})
+	if err != nil {
+		slog.Error("failed to subscribe to the room's events", "room", room.Name, "error", err)
+		return
+	}
+	room.unsubscribe = unsubscribe
+}
+
+// broadcast sends event to every member of room except exclude, on this node and, in
+// cluster mode, on the others.
+func (m *Manager) broadcast(ctx context.Context, room *Room, exclude *Client, event Event) {
+	msg := BusMessage{Event: event}
+	if exclude != nil {
+		msg.Exclude = exclude.ID
+	}
+
+	if err := m.bus.Publish(ctx, RoomTopic(room.Name), msg); err != nil {
+		slog.ErrorContext(ctx, "failed to publish room event", "room", room.Name, "event", event.Type, "error", err)
+	}
+}
+
+// sendTo sends event to the session, wherever it is connected.
+func (m *Manager) sendTo(ctx context.Context, sessionID string, event Event) error {
+	return m.bus.Publish(ctx, ClientTopic(sessionID), BusMessage{Event: event})
+}
+
+// sendRoomInfo sends room_info to every member of room, each with its own roles
+// towards the other members.
+func (m *Manager) sendRoomInfo(ctx context.Context, room *Room) error {
+	members := m.members(ctx, room)
+
+	for _, recipient := range members {
+		users := make([]RoomMember, 0, len(members))
+		for _, member := range members {
+			users = append(users, RoomMember{
+				Peer:   member.Peer,
+				Polite: recipient.seq > member.seq,
+			})
+		}
+
+		data, err := json.Marshal(RoomInfoEvent{
+			Room:  room.Name,
+			Mode:  room.Mode,
+			Users: users,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal room info event: %v", err)
+		}
+
+		if err := m.sendTo(ctx, recipient.Peer.SessionID, Event{Type: EventRoomInfo, Payload: data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webrtcConfig is the configuration of the server's own peer connections, in SFU rooms
+// and for recordings.
+func (m *Manager) webrtcConfig() webrtc.Configuration {
+	var config webrtc.Configuration
+	if len(m.ice.STUN_URLS) > 0 {
+		config.ICEServers = []webrtc.ICEServer{{URLs: m.ice.STUN_URLS}}
+	}
+	return config
+}
+
+// Deliver queues event on the egress of each client without waiting for any of them,
+// see egressQueue for what a full egress drops. A client whose egress stayed full for
+// the configured timeout, including a detached session that was not resumed in time,
+// is disconnected.
+func Deliver(clients []*Client, event Event) {
+	for _, client := range clients {
+		// a closing client or a dropped event is not the other recipients' problem
+		client.deliver(event)
+	}
+}
+
+// deliver queues event on c's egress, see Deliver.
+func (c *Client) deliver(event Event) error {
+	dropReason, fullFor, err := c.egress.push(event)
+	if errors.Is(err, errEgressOverflow) {
+		c.disconnectSlow("egress overflowed, disconnecting the client", "event", event.Type)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if dropReason != "" {
+		c.manager.metrics.egressDrops.WithLabelValues(dropReason).Inc()
+		c.logger().Debug("egress is full, dropped an event", "event", event.Type, "reason", dropReason)
+	}
+	if fullFor > c.manager.server.EGRESS_FULL_TIMEOUT {
+		c.disconnectSlow("egress stayed full, disconnecting the client", "full_for", fullFor)
+	}
+	if dropReason == dropNewest {
+		return ErrEventDropped
+	}
+	return nil
+}
+
+// disconnectSlow closes the client for not reading its events and removes it, msg and
+// args are logged with its egress stats. Only the first call has an effect.
+func (c *Client) disconnectSlow(msg string, args ...any) {
+	if !c.slow.CompareAndSwap(false, true) {
+		return
+	}
+
+	c.manager.metrics.slowClients.Inc()
+	stats := c.egress.stats()
+	c.logger().Warn(msg, append(args, "queued", stats.Queued, "dropped", stats.Dropped)...)
+	// the write goroutine gives up on the queued events once a write times out
+	c.close(closeSlowClient, "client is not reading its events")
+	go c.manager.removeClient(c)
+}
+
+// checkOrigin accepts the websocket upgrade only from the configured origins.
+func (m *Manager) checkOrigin(r *http.Request) bool {
+	return m.server.AllowOrigin(r.Header.Get("Origin"))
+}
@@ -0,0 +1,225 @@
+package signaling
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+// metricsNamespace prefixes every metric the manager exports.
+const metricsNamespace = "signaling"
+
+// unknownEventType is the type label of received events no handler is registered for,
+// so clients cannot grow the label set with made up types.
+const unknownEventType = "unknown"
+
+// Results of the login and otp counters.
+const (
+	resultSuccess      = "success"
+	resultUnauthorized = "unauthorized"
+	resultLocked       = "locked"
+	resultInvalid      = "invalid"
+	resultError        = "error"
+)
+
+// Reasons an event is dropped from a full egress, the oldest droppable event to make
+// room for a new one, or the new droppable event itself.
+const (
+	dropOldest = "oldest"
+	dropNewest = "newest"
+)
+
+// metrics holds the collectors of a Manager. Each manager has its own registry, so
+// several managers can run in one process, e.g. two cluster nodes in a test.
+type metrics struct {
+	registry *prometheus.Registry
+
+	eventsReceived  *prometheus.CounterVec
+	eventsSent      *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+	handlerErrors   *prometheus.CounterVec
+	egressDrops     *prometheus.CounterVec
+	clientDrops     prometheus.Histogram
+	slowClients     prometheus.Counter
+	errorReplies    *prometheus.CounterVec
+	pingRTT         prometheus.Histogram
+	logins          *prometheus.CounterVec
+	otpVerification *prometheus.CounterVec
+}
+
+// newMetrics registers the manager's collectors. The client, room and egress gauges
+// are read from m when scraped, the pool stats from pool if it is a *pgxpool.Pool.
+func newMetrics(m *Manager, pool db.PgxPool) *metrics {
+	mt := &metrics{
+		registry: prometheus.NewRegistry(),
+		eventsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "events_received_total",
+			Help:      "Events received from websocket clients, by event type.",
+		}, []string{"type"}),
+		eventsSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "events_sent_total",
+			Help:      "Events written to websocket clients, by event type.",
+		}, []string{"type"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "handler_duration_seconds",
+			Help:      "Time spent handling an event in routeEvent, by event type.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"type"}),
+		handlerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "handler_errors_total",
+			Help:      "Events whose handler returned an error, by event type.",
+		}, []string{"type"}),
+		egressDrops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "egress_drops_total",
+			Help:      "Events dropped from a full client egress, by reason.",
+		}, []string{"reason"}),
+		clientDrops: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "client_egress_drops",
+			Help:      "Events dropped from a client's full egress over its session, observed when it ends.",
+			Buckets:   []float64{0, 1, 10, 100, 1000, 10000},
+		}),
+		slowClients: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "slow_client_disconnects_total",
+			Help:      "Clients disconnected because their egress stayed full or overflowed.",
+		}),
+		errorReplies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "error_events_total",
+			Help:      "Error events sent to clients for events that failed, by code.",
+		}, []string{"code"}),
+		pingRTT: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "ping_rtt_seconds",
+			Help:      "Round trip time from a websocket ping to its pong.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "logins_total",
+			Help:      "Login attempts, by result.",
+		}, []string{"result"}),
+		otpVerification: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "otp_verifications_total",
+			Help:      "One-time passwords redeemed on the websocket upgrade, by result.",
+		}, []string{"result"}),
+	}
+
+	mt.registry.MustRegister(
+		mt.eventsReceived, mt.eventsSent, mt.handlerDuration, mt.handlerErrors,
+		mt.egressDrops, mt.clientDrops, mt.slowClients, mt.errorReplies, mt.pingRTT, mt.logins, mt.otpVerification,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "connected_clients",
+			Help:      "Websocket sessions, including detached ones waiting to be resumed.",
+		}, func() float64 {
+			m.RLock()
+			defer m.RUnlock()
+			return float64(len(m.clients))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_rooms",
+			Help:      "Rooms with at least one member.",
+		}, func() float64 {
+			m.RLock()
+			defer m.RUnlock()
+			return float64(len(m.rooms))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "egress_queued_events",
+			Help:      "Events queued on the egress of all clients.",
+		}, func() float64 {
+			m.RLock()
+			defer m.RUnlock()
+			queued := 0
+			for client := range m.clients {
+				queued += client.egress.len()
+			}
+			return float64(queued)
+		}),
+	)
+
+	if stater, ok := pool.(interface{ Stat() *pgxpool.Stat }); ok {
+		mt.registry.MustRegister(newPoolCollector(stater.Stat))
+	}
+
+	return mt
+}
+
+// observeHandler records how long handling an event of eventType took and whether it failed.
+func (mt *metrics) observeHandler(eventType string, startedAt time.Time, err error) {
+	mt.handlerDuration.WithLabelValues(eventType).Observe(time.Since(startedAt).Seconds())
+	if err != nil {
+		mt.handlerErrors.WithLabelValues(eventType).Inc()
+	}
+}
+
+// MetricsHandler serves the manager's metrics in the Prometheus exposition format.
+func (m *Manager) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(m.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// poolCollector exports the stats of a pgx pool when scraped.
+type poolCollector struct {
+	stat func() *pgxpool.Stat
+
+	acquiredConns     *prometheus.Desc
+	idleConns         *prometheus.Desc
+	totalConns        *prometheus.Desc
+	maxConns          *prometheus.Desc
+	acquireCount      *prometheus.Desc
+	acquireDuration   *prometheus.Desc
+	emptyAcquireCount *prometheus.Desc
+	canceledAcquires  *prometheus.Desc
+}
+
+func newPoolCollector(stat func() *pgxpool.Stat) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "db_pool", name), help, nil, nil)
+	}
+
+	return &poolCollector{
+		stat:              stat,
+		acquiredConns:     desc("acquired_conns", "Connections currently acquired from the pool."),
+		idleConns:         desc("idle_conns", "Idle connections in the pool."),
+		totalConns:        desc("total_conns", "Connections in the pool, acquired, idle and being opened."),
+		maxConns:          desc("max_conns", "Maximum size of the pool."),
+		acquireCount:      desc("acquires_total", "Successful acquires from the pool."),
+		acquireDuration:   desc("acquire_duration_seconds_total", "Time spent in successful acquires."),
+		emptyAcquireCount: desc("empty_acquires_total", "Acquires that had to wait for a connection."),
+		canceledAcquires:  desc("canceled_acquires_total", "Acquires cancelled by their context."),
+	}
+}
+
+func (p *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(p, ch)
+}
+
+func (p *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := p.stat()
+
+	ch <- prometheus.MustNewConstMetric(p.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(p.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(p.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(p.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(p.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(p.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(p.emptyAcquireCount, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(p.canceledAcquires, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+}
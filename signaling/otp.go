@@ -0,0 +1,269 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+// ErrInvalidOTP is returned when a one-time password is unknown, expired or already used.
+var ErrInvalidOTP = errors.New("invalid otp")
+
+// errOTPStoreClosed is returned by Ping after the store was closed.
+var errOTPStoreClosed = errors.New("otp store closed")
+
+// retentionInterval is how often expired one-time passwords are swept.
+const retentionInterval = 400 * time.Millisecond
+
+// Claims is the identity established at login that an OTP carries over to the websocket.
+type Claims struct {
+	UserID   int64
+	Username string
+}
+
+type OTP struct {
+	Key     string
+	Claims  Claims
+	Created time.Time
+}
+
+// OTPStore issues one-time passwords at login and redeems them on the websocket upgrade.
+// Implementations must be safe for concurrent use and must let every OTP be verified
+// successfully at most once, within its TTL.
+type OTPStore interface {
+	NewOTP(ctx context.Context, claims Claims) (OTP, error)
+	// VerifyOTP consumes the one-time password and returns the OTP it was issued as.
+	// It returns ErrInvalidOTP if the key is unknown, expired or already used.
+	VerifyOTP(ctx context.Context, key string) (OTP, error)
+	// Ping reports whether the store can issue and redeem passwords.
+	Ping(ctx context.Context) error
+	// Close stops sweeping expired passwords and waits for the sweeper to return,
+	// the store must not be used after that.
+	Close() error
+}
+
+// NewOTPStore returns the OTPStore selected by the configuration.
+func NewOTPStore(ctx context.Context, cfg config.OTPConfig, pool db.PgxPool) (OTPStore, error) {
+	if cfg.TTL <= 0 {
+		return nil, fmt.Errorf("otp ttl must be positive, got %s", cfg.TTL)
+	}
+
+	switch cfg.STORE {
+	case "memory":
+		return NewMemoryOTPStore(ctx, cfg.TTL), nil
+	case "postgres":
+		return NewPostgresOTPStore(ctx, pool, cfg.TTL), nil
+	default:
+		return nil, fmt.Errorf("unknown otp store %q", cfg.STORE)
+	}
+}
+
+// retention runs the Retention goroutine of an OTP store until its context is
+// cancelled or Close is called.
+type retention struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (r *retention) start(ctx context.Context, sweep func(ctx context.Context)) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+		sweep(ctx)
+	}()
+}
+
+// alive returns an error once the Retention goroutine returned, the store is closed then.
+func (r *retention) alive() error {
+	select {
+	case <-r.done:
+		return errOTPStoreClosed
+	default:
+		return nil
+	}
+}
+
+// Close stops the Retention goroutine and waits for it to return.
+func (r *retention) Close() error {
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+// MemoryOTPStore keeps one-time passwords in process memory.
+type MemoryOTPStore struct {
+	retention
+
+	mu   sync.Mutex
+	otps map[string]OTP
+	ttl  time.Duration
+}
+
+// NewMemoryOTPStore returns a MemoryOTPStore whose passwords expire after ttl.
+// Expired passwords are swept until ctx is cancelled or the store is closed.
+func NewMemoryOTPStore(ctx context.Context, ttl time.Duration) *MemoryOTPStore {
+	s := &MemoryOTPStore{
+		otps: make(map[string]OTP),
+		ttl:  ttl,
+	}
+
+	s.start(ctx, s.Retention)
+
+	return s
+}
+
+func (s *MemoryOTPStore) NewOTP(ctx context.Context, claims Claims) (OTP, error) {
+	o := OTP{
+		Key:     uuid.NewString(),
+		Claims:  claims,
+		Created: time.Now(),
+	}
+
+	s.mu.Lock()
+	s.otps[o.Key] = o
+	s.mu.Unlock()
+
+	return o, nil
+}
+
+func (s *MemoryOTPStore) VerifyOTP(ctx context.Context, key string) (OTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.otps[key]
+	if !ok {
+		return OTP{}, ErrInvalidOTP
+	}
+	delete(s.otps, key)
+
+	// the sweeper runs periodically, so an entry may outlive its ttl for a moment
+	if s.expired(o, time.Now()) {
+		return OTP{}, ErrInvalidOTP
+	}
+	return o, nil
+}
+
+func (s *MemoryOTPStore) Ping(ctx context.Context) error {
+	return s.alive()
+}
+
+func (s *MemoryOTPStore) expired(o OTP, now time.Time) bool {
+	return o.Created.Add(s.ttl).Before(now)
+}
+
+// Retention removes expired one-time passwords until ctx is cancelled.
+func (s *MemoryOTPStore) Retention(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.mu.Lock()
+			for key, otp := range s.otps {
+				if s.expired(otp, now) {
+					delete(s.otps, key)
+				}
+			}
+			s.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// PostgresOTPStore keeps one-time passwords in the otps table, so a ticket issued
+// by one server instance can be redeemed on another.
+type PostgresOTPStore struct {
+	retention
+
+	pool db.PgxPool
+	otps *db.OTPRepository
+	ttl  time.Duration
+}
+
+// NewPostgresOTPStore returns a PostgresOTPStore whose passwords expire after ttl.
+// Expired rows are deleted until ctx is cancelled or the store is closed, so close
+// it before the pool.
+func NewPostgresOTPStore(ctx context.Context, pool db.PgxPool, ttl time.Duration) *PostgresOTPStore {
+	s := &PostgresOTPStore{
+		pool: pool,
+		otps: db.NewOTPRepository(pool),
+		ttl:  ttl,
+	}
+
+	s.start(ctx, s.Retention)
+
+	return s
+}
+
+func (s *PostgresOTPStore) NewOTP(ctx context.Context, claims Claims) (OTP, error) {
+	row, err := s.otps.Insert(ctx, db.OTP{
+		Key:      uuid.NewString(),
+		UserID:   claims.UserID,
+		Username: claims.Username,
+	}, s.ttl)
+	if err != nil {
+		return OTP{}, err
+	}
+
+	return OTP{
+		Key:     row.Key,
+		Claims:  claims,
+		Created: row.CreatedAt,
+	}, nil
+}
+
+func (s *PostgresOTPStore) Ping(ctx context.Context) error {
+	if err := s.alive(); err != nil {
+		return err
+	}
+	return db.Ping(ctx, s.pool)
+}
+
+func (s *PostgresOTPStore) VerifyOTP(ctx context.Context, key string) (OTP, error) {
+	row, err := s.otps.Consume(ctx, key)
+	if errors.Is(err, db.ErrOTPNotFound) {
+		return OTP{}, ErrInvalidOTP
+	}
+	if err != nil {
+		return OTP{}, err
+	}
+
+	return OTP{
+		Key: row.Key,
+		Claims: Claims{
+			UserID:   row.UserID,
+			Username: row.Username,
+		},
+		Created: row.CreatedAt,
+	}, nil
+}
+
+// Retention deletes expired rows until ctx is cancelled. Expired rows are never
+// accepted by VerifyOTP, so this only keeps the table small.
+func (s *PostgresOTPStore) Retention(ctx context.Context) {
+	ticker := time.NewTicker(s.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.otps.DeleteExpired(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("failed to delete expired otps", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+// testDatabaseURL names the environment variable with the database the Postgres
+// tests run against, they are skipped without it.
+const testDatabaseURL = "SIGNALING_TEST_DATABASE_URL"
+
+// testPool connects to the test database and migrates it.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	url := os.Getenv(testDatabaseURL)
+	if url == "" {
+		t.Skipf("%s is not set", testDatabaseURL)
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	if err := db.Migrate(ctx, pool); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return pool
+}
+
+// otpStores returns a constructor for each OTPStore, the Postgres one is skipped
+// without a test database.
+func otpStores() map[string]func(t *testing.T, ttl time.Duration) OTPStore {
+	return map[string]func(t *testing.T, ttl time.Duration) OTPStore{
+		"memory": func(t *testing.T, ttl time.Duration) OTPStore {
+			s := NewMemoryOTPStore(context.Background(), ttl)
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		"postgres": func(t *testing.T, ttl time.Duration) OTPStore {
+			s := NewPostgresOTPStore(context.Background(), testPool(t), ttl)
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+}
+
+func TestOTPStoreVerifyOnce(t *testing.T) {
+	for name, newStore := range otpStores() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t, time.Minute)
+			ctx := context.Background()
+
+			claims := Claims{UserID: 7, Username: "alice"}
+			o, err := s.NewOTP(ctx, claims)
+			if err != nil {
+				t.Fatalf("NewOTP: %v", err)
+			}
+
+			const verifiers = 32
+			var (
+				wg        sync.WaitGroup
+				start     = make(chan struct{})
+				successes atomic.Int32
+			)
+			for i := 0; i < verifiers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+
+					got, err := s.VerifyOTP(ctx, o.Key)
+					switch {
+					case err == nil:
+						successes.Add(1)
+						if got.Claims != claims {
+							t.Errorf("VerifyOTP claims = %+v, want %+v", got.Claims, claims)
+						}
+					case !errors.Is(err, ErrInvalidOTP):
+						t.Errorf("VerifyOTP: %v", err)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			if n := successes.Load(); n != 1 {
+				t.Fatalf("%d of %d concurrent VerifyOTP calls succeeded, want exactly 1", n, verifiers)
+			}
+		})
+	}
+}
+
+func TestOTPStoreExpiry(t *testing.T) {
+	for name, newStore := range otpStores() {
+		t.Run(name, func(t *testing.T) {
+			const ttl = 100 * time.Millisecond
+			s := newStore(t, ttl)
+			ctx := context.Background()
+
+			o, err := s.NewOTP(ctx, Claims{UserID: 7, Username: "alice"})
+			if err != nil {
+				t.Fatalf("NewOTP: %v", err)
+			}
+			time.Sleep(2 * ttl)
+
+			if _, err := s.VerifyOTP(ctx, o.Key); !errors.Is(err, ErrInvalidOTP) {
+				t.Fatalf("VerifyOTP after ttl = %v, want %v", err, ErrInvalidOTP)
+			}
+		})
+	}
+}
+
+func TestMemoryOTPStoreRetention(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	s := NewMemoryOTPStore(context.Background(), ttl)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.NewOTP(context.Background(), Claims{UserID: int64(i)}); err != nil {
+			t.Fatalf("NewOTP: %v", err)
+		}
+	}
+	time.Sleep(ttl + 2*retentionInterval)
+
+	s.mu.Lock()
+	left := len(s.otps)
+	s.mu.Unlock()
+	if left != 0 {
+		t.Fatalf("%d expired passwords left after the sweep", left)
+	}
+}
+
+func TestPostgresOTPStoreRetention(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	const ttl = 100 * time.Millisecond
+	s := NewPostgresOTPStore(ctx, pool, ttl)
+	defer s.Close()
+
+	o, err := s.NewOTP(ctx, Claims{UserID: 7, Username: "alice"})
+	if err != nil {
+		t.Fatalf("NewOTP: %v", err)
+	}
+	time.Sleep(3 * ttl)
+
+	var left bool
+	if err := pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM otps WHERE key = $1)`, o.Key).Scan(&left); err != nil {
+		t.Fatalf("query otps: %v", err)
+	}
+	if left {
+		t.Fatal("expired password left after the sweep")
+	}
+}
@@ -0,0 +1,396 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+// RecorderPeerID is the From of the offers the recorder sends while a room is recorded,
+// clients address the recorder with it as To.
+const RecorderPeerID = "recorder"
+
+// unsafeFileChars matches what is replaced in usernames used as part of file names.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// recording archives the media of a room. While it runs the server joins the room as a
+// receive-only peer, every member gets an offer from RecorderPeerID, and each audio and
+// video track it sends is written to its own file as it arrives, without transcoding.
+// The recorder's ICE candidates are trickled with ice_candidate.
+type recording struct {
+	ID        int64
+	Room      string
+	StartedAt time.Time
+	dir       string
+
+	api        *webrtc.API
+	config     webrtc.Configuration
+	recordings *db.RecordingRepository
+
+	mu sync.Mutex
+	// peers indexes the recorder's peer connections by the member's session ID.
+	peers   map[string]*webrtc.PeerConnection
+	stopped bool
+	// files numbers the recording's track files, a member that rejoins or restarts ICE
+	// sends its tracks again on a new peer connection, which must not overwrite the old files.
+	files int
+	// tracks waits for the track writers still running.
+	tracks sync.WaitGroup
+}
+
+// startRecording stores a new recording of room started by owner and creates its directory.
+func (m *Manager) startRecording(ctx context.Context, room *Room, owner *Client) (*recording, error) {
+	api, err := newRecorderAPI()
+	if err != nil {
+		return nil, err
+	}
+
+	row, err := m.recordings.Start(ctx, room.Name, owner.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(m.recordingDir, strconv.FormatInt(row.ID, 10))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		// the row would list a recording without files
+		if deleteErr := m.recordings.Delete(ctx, row.ID); deleteErr != nil {
+			slog.ErrorContext(ctx, "failed to delete recording", "recording", row.ID, "error", deleteErr)
+		}
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+
+	return &recording{
+		ID:         row.ID,
+		Room:       room.Name,
+		StartedAt:  row.StartedAt,
+		dir:        dir,
+		api:        api,
+		config:     m.webrtcConfig(),
+		recordings: m.recordings,
+		peers:      make(map[string]*webrtc.PeerConnection),
+	}, nil
+}
+
+// newRecorderAPI returns a pion API that only negotiates the codecs the recorder can
+// write as they are, Opus to Ogg and VP8 to IVF.
+func newRecorderAPI() (*webrtc.API, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1",
+		},
+		PayloadType: 111,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, fmt.Errorf("failed to register opus: %w", err)
+	}
+
+	if err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+		PayloadType:        96,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		return nil, fmt.Errorf("failed to register vp8: %w", err)
+	}
+
+	interceptors := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptors); err != nil {
+		return nil, fmt.Errorf("failed to register interceptors: %w", err)
+	}
+
+	return webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithInterceptorRegistry(interceptors)), nil
+}
+
+// addPeer offers c a receive-only peer connection to the recorder.
+func (r *recording) addPeer(c *Client) error {
+	pc, err := r.api.NewPeerConnection(r.config)
+	if err != nil {
+		return fmt.Errorf("failed to create peer connection: %w", err)
+	}
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		}); err != nil {
+			pc.Close()
+			return fmt.Errorf("failed to add %s transceiver: %w", kind, err)
+		}
+	}
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed {
+			pc.Close()
+		}
+	})
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		r.record(c, pc, remote)
+	})
+
+	// offering is held until the offer is sent, so the member never gets a candidate
+	// before it
+	var offering sync.Mutex
+	offering.Lock()
+	defer offering.Unlock()
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		offering.Lock()
+		defer offering.Unlock()
+
+		sendCandidate(c, r.Room, RecorderPeerID, candidate)
+	})
+
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		pc.Close()
+		return nil
+	}
+	old := r.peers[c.ID]
+	r.peers[c.ID] = pc
+	r.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	data, err := json.Marshal(OfferEvent{
+		Room: r.Room,
+		From: RecorderPeerID,
+		To:   c.ID,
+		Sdp:  pc.LocalDescription().SDP,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal offer event: %v", err)
+	}
+
+	return c.Send(c.Context(), Event{Type: EventOffer, Payload: data})
+}
+
+// removePeer stops recording c, its files are finished in the background.
+func (r *recording) removePeer(c *Client) {
+	r.mu.Lock()
+	pc := r.peers[c.ID]
+	delete(r.peers, c.ID)
+	r.mu.Unlock()
+
+	if pc != nil {
+		go pc.Close()
+	}
+}
+
+func (r *recording) peer(sessionID string) *webrtc.PeerConnection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.peers[sessionID]
+}
+
+// connection returns the recorder's peer connection with c, it fails with
+// ErrCodeNoPeerConnection if there is none.
+func (r *recording) connection(c *Client) (*webrtc.PeerConnection, error) {
+	pc := r.peer(c.ID)
+	if pc == nil {
+		return nil, NewHandlerError(ErrCodeNoPeerConnection, "no recorder peer connection, wait for the recorder's offer")
+	}
+	return pc, nil
+}
+
+// handleAnswer applies the member's answer to the recorder's offer.
+func (r *recording) handleAnswer(c *Client, sdp string) error {
+	pc, err := r.connection(c)
+	if err != nil {
+		return err
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp}); err != nil {
+		return fmt.Errorf("failed to set remote description: %w", err)
+	}
+	return nil
+}
+
+// handleCandidate adds a trickled ICE candidate of the member.
+func (r *recording) handleCandidate(c *Client, candidate json.RawMessage) error {
+	pc, err := r.connection(c)
+	if err != nil {
+		return err
+	}
+
+	var init webrtc.ICECandidateInit
+	if err := json.Unmarshal(candidate, &init); err != nil {
+		return NewHandlerError(ErrCodeBadRequest, fmt.Sprintf("bad ice candidate: %v", err))
+	}
+
+	return pc.AddICECandidate(init)
+}
+
+// record writes remote to a file until the member stops sending it or the recording
+// stops, then stores the file in the recording_files table.
+func (r *recording) record(c *Client, pc *webrtc.PeerConnection, remote *webrtc.TrackRemote) {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return
+	}
+	r.tracks.Add(1)
+	r.files++
+	seq := r.files
+	r.mu.Unlock()
+	defer r.tracks.Done()
+
+	codec := remote.Codec()
+	kind := remote.Kind().String()
+	name := trackFileName(c, kind, seq)
+
+	logger := c.logger().With("recording", r.ID, "room", r.Room, "kind", kind)
+
+	writer, path, err := newTrackWriter(r.dir, name, codec.RTPCodecCapability)
+	if err != nil {
+		logger.Error("failed to create track file", "error", err)
+		return
+	}
+
+	done := make(chan struct{})
+	if remote.Kind() == webrtc.RTPCodecTypeVideo {
+		// the file can only start decoding at a keyframe, ask for them like the SFU does
+		go requestTrackKeyframes(pc, remote.SSRC(), done)
+	}
+
+	startedAt := time.Now()
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			break
+		}
+
+		if err := writer.WriteRTP(packet); err != nil {
+			logger.Error("failed to write track", "path", path, "error", err)
+			break
+		}
+	}
+	close(done)
+
+	if err := writer.Close(); err != nil {
+		logger.Error("failed to close track file", "path", path, "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := r.recordings.AddFile(ctx, db.RecordingFile{
+		RecordingID: r.ID,
+		UserID:      c.UserID,
+		Username:    c.Username,
+		SessionID:   c.ID,
+		Kind:        kind,
+		Codec:       codec.MimeType,
+		Path:        path,
+		StartedAt:   startedAt,
+		Duration:    time.Since(startedAt),
+	}); err != nil {
+		logger.Error("failed to store recording file", "path", path, "error", err)
+	}
+}
+
+// stop closes the recorder's peer connections, waits for every file to be finished
+// and stores the recording's duration. It returns the duration.
+func (r *recording) stop() (time.Duration, error) {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return 0, nil
+	}
+	r.stopped = true
+	peers := r.peers
+	r.peers = nil
+	r.mu.Unlock()
+
+	for _, pc := range peers {
+		pc.Close()
+	}
+	r.tracks.Wait()
+
+	stoppedAt := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if err := r.recordings.Stop(ctx, r.ID, stoppedAt); err != nil {
+		return 0, err
+	}
+	return stoppedAt.Sub(r.StartedAt), nil
+}
+
+// trackFileName names the seq-th file of a recording, a track of kind sent by c.
+func trackFileName(c *Client, kind string, seq int) string {
+	return fmt.Sprintf("%s-%s-%s-%d", unsafeFileChars.ReplaceAllString(c.Username, "_"), c.ID, kind, seq)
+}
+
+// trackWriter writes the RTP packets of a single track to a file as they are.
+// It does not depend on a peer connection, so it can be fed synthetic RTP.
+type trackWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// newTrackWriter creates the file for a track named name in dir and returns a writer
+// for it with its path. Opus is written to Ogg and VP8 to IVF, other codecs are refused.
+func newTrackWriter(dir, name string, codec webrtc.RTPCodecCapability) (trackWriter, string, error) {
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeOpus):
+		path := filepath.Join(dir, name+".ogg")
+		writer, err := oggwriter.New(path, codec.ClockRate, codec.Channels)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		return writer, path, nil
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8):
+		path := filepath.Join(dir, name+".ivf")
+		writer, err := ivfwriter.New(path, ivfwriter.WithCodec(webrtc.MimeTypeVP8))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		return writer, path, nil
+	default:
+		return nil, "", fmt.Errorf("cannot record codec %s", codec.MimeType)
+	}
+}
+
+// requestTrackKeyframes sends a picture loss indication for ssrc every keyframeInterval until done is closed.
+func requestTrackKeyframes(pc *webrtc.PeerConnection, ssrc webrtc.SSRC, done <-chan struct{}) {
+	ticker := time.NewTicker(keyframeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)}})
+		}
+	}
+}
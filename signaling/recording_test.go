@@ -0,0 +1,158 @@
+package signaling
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+// recordingsPool is a database pool that inserts every recording with ID 1 and
+// remembers the statements executed on it.
+type recordingsPool struct {
+	db.PgxPool
+	executed []string
+}
+
+// insertedRecording scans the row of an inserted recording.
+type insertedRecording struct{}
+
+func (insertedRecording) Scan(dest ...any) error {
+	*dest[0].(*int64) = 1
+	*dest[1].(*time.Time) = time.Now()
+	return nil
+}
+
+func (p *recordingsPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return insertedRecording{}
+}
+
+func (p *recordingsPool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	p.executed = append(p.executed, sql)
+	return pgconn.CommandTag{}, nil
+}
+
+func TestStartRecordingDeletesRowWithoutDirectory(t *testing.T) {
+	// the recordings directory is a file, no recording directory can be created in it
+	recordingDir := filepath.Join(t.TempDir(), "recordings")
+	if err := os.WriteFile(recordingDir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pool := &recordingsPool{}
+	m := NewManager(pool, nil, config.ICEConfig{}, config.RecordingConfig{DIR: recordingDir}, config.ServerConfig{})
+	c := &Client{ID: "session", UserID: 7, Username: "alice"}
+
+	if _, err := m.startRecording(context.Background(), newRoom("lobby", c.UserID, RoomOptions{}), c); err == nil {
+		t.Fatal("startRecording succeeded without a directory")
+	}
+	if len(pool.executed) != 1 || !strings.HasPrefix(pool.executed[0], "DELETE FROM recordings") {
+		t.Fatalf("executed %q, want the inserted recording deleted", pool.executed)
+	}
+}
+
+func TestTrackFileNameUnique(t *testing.T) {
+	c := &Client{ID: "5b0e7c1c-3c7e-4a53-9a4c-4f3f0b1c2d3e", Username: "al/ice"}
+
+	// the same member sends its audio again on a new peer connection
+	first := trackFileName(c, "audio", 1)
+	second := trackFileName(c, "audio", 2)
+	if first == second {
+		t.Fatalf("both tracks are written to %q", first)
+	}
+	if strings.ContainsRune(first, '/') {
+		t.Fatalf("file name %q contains a path separator", first)
+	}
+}
+
+func TestTrackWriterVP8(t *testing.T) {
+	writer, path, err := newTrackWriter(t.TempDir(), "video", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000})
+	if err != nil {
+		t.Fatalf("newTrackWriter: %v", err)
+	}
+
+	const frames = 3
+	for i := 0; i < frames; i++ {
+		// a VP8 payload descriptor starting a partition, then a keyframe header byte
+		packet := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    96,
+				SequenceNumber: uint16(i),
+				Timestamp:      uint32(i * 3000),
+				Marker:         true,
+			},
+			Payload: []byte{0x10, 0x00, 0x9d, 0x01, 0x2a},
+		}
+		if err := writer.WriteRTP(packet); err != nil {
+			t.Fatalf("WriteRTP: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if !bytes.HasPrefix(data, []byte("DKIF")) {
+		t.Fatalf("%s is not an IVF file", path)
+	}
+	if n := binary.LittleEndian.Uint32(data[24:28]); n != frames {
+		t.Fatalf("IVF header counts %d frames, want %d", n, frames)
+	}
+}
+
+func TestTrackWriterOpus(t *testing.T) {
+	writer, path, err := newTrackWriter(t.TempDir(), "audio", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2})
+	if err != nil {
+		t.Fatalf("newTrackWriter: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		packet := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    111,
+				SequenceNumber: uint16(i),
+				Timestamp:      uint32(i * 960),
+			},
+			Payload: []byte{0xfc, 0xff, 0xfe},
+		}
+		if err := writer.WriteRTP(packet); err != nil {
+			t.Fatalf("WriteRTP: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if !bytes.HasPrefix(data, []byte("OggS")) {
+		t.Fatalf("%s is not an Ogg file", path)
+	}
+	// the two header pages, then a page per packet
+	if pages := bytes.Count(data, []byte("OggS")); pages != 2+3 {
+		t.Fatalf("%s has %d Ogg pages, want %d", path, pages, 2+3)
+	}
+}
+
+func TestTrackWriterRefusesCodec(t *testing.T) {
+	if _, _, err := newTrackWriter(t.TempDir(), "video", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}); err == nil {
+		t.Fatal("newTrackWriter accepted H264")
+	}
+}
@@ -0,0 +1,213 @@
+package signaling
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultRoomCapacity is the member limit of a room created without an explicit capacity.
+const defaultRoomCapacity = 16
+
+// Room modes select how media flows between the members of a room.
+const (
+	// RoomModeMesh has every pair of members connect directly, the server only relays signaling.
+	RoomModeMesh = "mesh"
+	// RoomModeSFU has every member connect once to the server, which forwards each
+	// member's tracks to the others.
+	RoomModeSFU = "sfu"
+)
+
+// ErrRoomFull is returned when joining a room that has reached its capacity.
+var ErrRoomFull error = NewHandlerError(ErrCodeRoomFull, "room is full")
+
+// RoomOptions are the settings a room is created with, they are ignored when
+// joining a room that already exists.
+type RoomOptions struct {
+	// Capacity limits the room's members, zero means defaultRoomCapacity.
+	Capacity int
+	// Mode is RoomModeMesh or RoomModeSFU, empty means RoomModeMesh.
+	Mode string
+}
+
+func (o RoomOptions) validate() error {
+	switch o.Mode {
+	case "", RoomModeMesh, RoomModeSFU:
+		return nil
+	default:
+		return NewHandlerError(ErrCodeBadRequest, fmt.Sprintf("unknown room mode %q", o.Mode))
+	}
+}
+
+// Room is a named group of clients that chat and signaling events fan out to.
+// Rooms are created by the Manager when the first client joins and removed
+// again once the last one leaves.
+type Room struct {
+	Name      string
+	CreatedAt time.Time
+	// Owner is the user ID of the client that created the room.
+	Owner    int64
+	Capacity int
+	Mode     string
+
+	// sfu forwards the room's media, it is nil unless Mode is RoomModeSFU.
+	sfu *sfu
+
+	mu sync.RWMutex
+	// members indexes the clients in the room by session ID.
+	members map[string]*Client
+	// ready holds the session IDs of members that sent user_ready.
+	ready map[string]bool
+	// joined numbers the members in the order they joined, joins is the last number handed out.
+	joined map[string]uint64
+	joins  uint64
+	// recording is the room's running recording, nil while it is not being recorded.
+	recording *recording
+	// unsubscribe ends the room's subscription to its bus topic once it is reaped, it is
+	// guarded by the manager's lock.
+	unsubscribe func()
+}
+
+func newRoom(name string, owner int64, options RoomOptions) *Room {
+	if options.Capacity <= 0 {
+		options.Capacity = defaultRoomCapacity
+	}
+	if options.Mode == "" {
+		options.Mode = RoomModeMesh
+	}
+
+	return &Room{
+		Name:      name,
+		CreatedAt: time.Now(),
+		Owner:     owner,
+		Capacity:  options.Capacity,
+		Mode:      options.Mode,
+		members:   make(map[string]*Client),
+		ready:     make(map[string]bool),
+		joined:    make(map[string]uint64),
+	}
+}
+
+// add makes c a member of the room, it returns ErrRoomFull if there is no space left.
+func (r *Room) add(c *Client) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.members) >= r.Capacity {
+		return ErrRoomFull
+	}
+	r.members[c.ID] = c
+	r.joins++
+	r.joined[c.ID] = r.joins
+	return nil
+}
+
+// remove drops c from the room and returns the number of members left.
+func (r *Room) remove(c *Client) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.members, c.ID)
+	delete(r.ready, c.ID)
+	delete(r.joined, c.ID)
+	return len(r.members)
+}
+
+// markReady records that c's media is ready and returns the other members that
+// were ready before it. Those are the peers that should send c an offer.
+func (r *Room) markReady(c *Client) []*Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.members[c.ID]; !ok {
+		return nil
+	}
+
+	var others []*Client
+	for id := range r.ready {
+		if id != c.ID {
+			others = append(others, r.members[id])
+		}
+	}
+	r.ready[c.ID] = true
+	return others
+}
+
+// activeRecording returns the room's running recording, or nil if there is none.
+func (r *Room) activeRecording() *recording {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.recording
+}
+
+// setRecording makes rec the room's running recording, it returns false if the
+// room is already being recorded.
+func (r *Room) setRecording(rec *recording) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.recording != nil {
+		return false
+	}
+	r.recording = rec
+	return true
+}
+
+// takeRecording clears the room's running recording and returns it, or nil if there is none.
+func (r *Room) takeRecording() *recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.recording
+	r.recording = nil
+	return rec
+}
+
+// Len returns the number of members in the room.
+func (r *Room) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.members)
+}
+
+// Clients returns a snapshot of the room's members, so callers can send
+// to them without holding the room lock.
+func (r *Room) Clients() []*Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(r.members))
+	for _, client := range r.members {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Client returns the member with the given session ID, or nil if there is none.
+func (r *Room) Client(sessionID string) *Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.members[sessionID]
+}
+
+// joinSeq returns the number the member with the given session ID joined as.
+func (r *Room) joinSeq(sessionID string) uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.joined[sessionID]
+}
+
+// setJoinSeq renumbers a member with the sequence number the cluster assigned it,
+// so the members of every node agree on who joined first.
+func (r *Room) setJoinSeq(sessionID string, seq uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.members[sessionID]; ok {
+		r.joined[sessionID] = seq
+	}
+}
@@ -0,0 +1,154 @@
+package signaling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/zenk41/learn-webrtc/signaling/config"
+	"github.com/zenk41/learn-webrtc/signaling/db"
+)
+
+const (
+	// shutdownTimeout bounds draining the HTTP server and the websocket clients.
+	shutdownTimeout = 10 * time.Second
+	// reconnectAfter is the hint sent to clients in server_shutdown.
+	reconnectAfter = 5 * time.Second
+)
+
+// Run loads the configuration from the environment, connects to and migrates the
+// database and serves the signaling server with the static files of frontendDir until
+// ctx is done, then shuts down gracefully. Its deferred calls release everything it
+// set up, also when it fails.
+func Run(ctx context.Context, frontendDir string) error {
+	// a malformed setting fails before anything is started
+	serverConfig, err := config.LoadServerConfig()
+	if err != nil {
+		return err
+	}
+	otpConfig, err := config.LoadOTPConfig()
+	if err != nil {
+		return err
+	}
+	iceConfig, err := config.LoadICEConfig()
+	if err != nil {
+		return err
+	}
+	clusterConfig, err := config.LoadClusterConfig()
+	if err != nil {
+		return err
+	}
+
+	dbPool, err := db.InitDB()
+	if err != nil {
+		return err
+	}
+	// the deferred calls run in reverse, the pool is closed last
+	defer dbPool.Close()
+
+	if err := db.Migrate(ctx, dbPool); err != nil {
+		return err
+	}
+
+	otps, err := NewOTPStore(context.Background(), otpConfig, dbPool)
+	if err != nil {
+		return err
+	}
+	defer otps.Close()
+
+	manager := NewManager(dbPool, otps, iceConfig, config.LoadRecordingConfig(), serverConfig)
+
+	if clusterConfig.ENABLED {
+		cluster, err := StartCluster(ctx, dbPool, clusterConfig, manager)
+		if err != nil {
+			return err
+		}
+		// runs after the manager shut down, so the node's members are removed with it
+		defer cluster.Close()
+	}
+
+	if turnConfig := config.LoadTURNConfig(); turnConfig.ENABLED {
+		turnServer, err := NewTURNServer(turnConfig)
+		if err != nil {
+			return err
+		}
+		defer turnServer.Close()
+	}
+
+	tlsConfig, challengeHandler, err := NewTLSConfig(serverConfig)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:      serverConfig.LISTEN_ADDR,
+		Handler:   manager.routes(frontendDir),
+		TLSConfig: tlsConfig,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if tlsConfig == nil {
+			// TLS is terminated by a proxy in front of the server
+			serveErr <- server.ListenAndServe()
+			return
+		}
+		// the certificates come from TLSConfig
+		serveErr <- server.ListenAndServeTLS("", "")
+	}()
+
+	// in acme mode HTTP-01 challenges are answered on a plain HTTP listener
+	var challengeServer *http.Server
+	if challengeHandler != nil && serverConfig.ACME_HTTP_ADDR != "" {
+		challengeServer = &http.Server{Addr: serverConfig.ACME_HTTP_ADDR, Handler: challengeHandler}
+		go func() {
+			serveErr <- challengeServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// drain the websockets first, /readyz reports not ready meanwhile and new upgrades
+	// are refused, then stop the HTTP server, the websockets are hijacked so it does
+	// not wait for them
+	if err := manager.Shutdown(shutdownCtx, reconnectAfter); err != nil {
+		slog.Error("failed to drain clients", "error", err)
+	}
+
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("failed to shut down http server", "error", err)
+	}
+	if challengeServer != nil {
+		if err := challengeServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("failed to shut down acme challenge server", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// routes returns the handler of the server's endpoints, serving the files of
+// frontendDir for every other path.
+func (m *Manager) routes(frontendDir string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir(frontendDir)))
+	mux.HandleFunc("/ws", m.ServeWS)
+	mux.HandleFunc("/login", m.LoginHandler)
+	mux.HandleFunc("/register", m.RegisterHandler)
+	mux.HandleFunc("/healthz", m.HealthzHandler)
+	mux.HandleFunc("/readyz", m.ReadyzHandler)
+	mux.Handle("/metrics", m.MetricsHandler())
+	return mux
+}
@@ -0,0 +1,135 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// dropClient is called by the read or write goroutine of conn once the websocket
+// failed or was closed. If resumable is set the session is detached for resumeGrace:
+// the client stays in its room, the other members are not told, and events sent to
+// it are queued until it resumes. Otherwise, or while shutting down, the client is
+// removed. Calls for a websocket that is no longer the client's current one are ignored.
+func (m *Manager) dropClient(c *Client, conn *websocket.Conn, resumable bool) {
+	m.Lock()
+	if _, ok := m.clients[c]; !ok || c.connection != conn || c.detached.Load() {
+		m.Unlock()
+		return
+	}
+
+	if !resumable || m.shuttingDown {
+		m.Unlock()
+		m.removeClient(c)
+		return
+	}
+
+	// the write goroutine closes conn once it stopped writing
+	close(c.stop)
+	c.detached.Store(true)
+
+	token := c.token
+	c.expire = time.AfterFunc(resumeGrace, func() {
+		m.expireSession(c, token)
+	})
+	m.Unlock()
+
+	c.logger().Info("session detached, waiting for it to resume", "grace", resumeGrace)
+}
+
+// expireSession removes c if it is still detached with the given token.
+func (m *Manager) expireSession(c *Client, token string) {
+	m.RLock()
+	expired := c.detached.Load() && c.token == token
+	m.RUnlock()
+
+	if expired {
+		c.logger().Info("session expired")
+		m.removeClient(c)
+	}
+}
+
+// resumeSession attaches conn to the detached session the token was issued for and
+// gives the session a new token. It returns nil if there is no such session.
+func (m *Manager) resumeSession(token string, conn *websocket.Conn) (*Client, chan struct{}) {
+	m.Lock()
+	defer m.Unlock()
+
+	c, ok := m.sessions[token]
+	if !ok || !c.detached.Load() {
+		return nil, nil
+	}
+
+	c.expire.Stop()
+	delete(m.sessions, token)
+
+	c.token = newSessionToken()
+	m.sessions[c.token] = c
+	c.connection = conn
+	c.stop = make(chan struct{})
+	c.detached.Store(false)
+
+	return c, c.stop
+}
+
+// resumeWS upgrades a request carrying a resume token. The websocket is upgraded
+// before the token is checked, so an unknown or expired token can be told apart
+// from a failed upgrade: the websocket is closed with closeSessionExpired.
+func (m *Manager) resumeWS(ctx context.Context, w http.ResponseWriter, r *http.Request, token string) {
+	conn, err := m.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to upgrade websocket", "error", err)
+		return
+	}
+
+	client, stop := m.resumeSession(token, conn)
+	if client == nil {
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(closeSessionExpired, "session expired, log in again"))
+		conn.Close()
+		return
+	}
+
+	ctx = client.logContext(ctx)
+	slog.InfoContext(ctx, "session resumed")
+
+	// the queued events go out first, then the new token. The websocket outlives
+	// the request, its context must not be cancelled with it
+	client.serve(context.WithoutCancel(ctx), conn, stop)
+
+	if err := m.sendSession(ctx, client); err != nil {
+		slog.ErrorContext(ctx, "failed to send session", "error", err)
+	}
+	if err := m.sendICEConfig(ctx, client); err != nil {
+		slog.ErrorContext(ctx, "failed to send ice config", "error", err)
+	}
+}
+
+// sendSession sends c its session ID and current resume token.
+func (m *Manager) sendSession(ctx context.Context, c *Client) error {
+	m.RLock()
+	token := c.token
+	m.RUnlock()
+
+	data, err := json.Marshal(SessionEvent{
+		SessionID:      c.ID,
+		Token:          token,
+		ResumeWithinMs: resumeGrace.Milliseconds(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal session event: %v", err)
+	}
+
+	return c.Send(ctx, Event{Type: EventSession, Payload: data})
+}
+
+// newSessionToken returns a random resume token, random like the OTP keys.
+func newSessionToken() string {
+	return uuid.NewString()
+}
@@ -0,0 +1,474 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// SFUPeerID is the From of the offer, answer and ice_candidate events the server
+// sends in an SFU room, clients address the server with it as To.
+const SFUPeerID = "sfu"
+
+// keyframeInterval is how often the SFU asks publishers for a keyframe, so members
+// that joined late or lost packets can start decoding again.
+const keyframeInterval = 3 * time.Second
+
+// sfu forwards the media of an SFU room. Each member has a single RTCPeerConnection
+// with the server, the tracks it publishes are forwarded to every other member.
+// The server makes the offers, it renegotiates with every member whenever a track
+// is published or goes away. Its ICE candidates are trickled with ice_candidate.
+type sfu struct {
+	room   string
+	config webrtc.Configuration
+
+	mu sync.Mutex
+	// peers indexes the members' peer connections by session ID.
+	peers map[string]*sfuPeer
+	// tracks indexes the forwarded tracks by ID.
+	tracks map[string]*sfuTrack
+
+	done chan struct{}
+}
+
+type sfuPeer struct {
+	client *Client
+	pc     *webrtc.PeerConnection
+
+	// mu serialises the negotiation with the member.
+	mu sync.Mutex
+	// awaitingAnswer is set while an offer sent to the member is unanswered,
+	// renegotiate records that the tracks changed in the meantime.
+	awaitingAnswer bool
+	renegotiate    bool
+	// iceRestart makes the next offer restart ICE.
+	iceRestart bool
+}
+
+// sfuTrack is a track published by the member with session ID owner.
+type sfuTrack struct {
+	local *webrtc.TrackLocalStaticRTP
+	owner string
+}
+
+func newSFU(room string, config webrtc.Configuration) *sfu {
+	s := &sfu{
+		room:   room,
+		config: config,
+		peers:  make(map[string]*sfuPeer),
+		tracks: make(map[string]*sfuTrack),
+		done:   make(chan struct{}),
+	}
+
+	go s.requestKeyframes()
+
+	return s
+}
+
+// close stops the SFU and closes the peer connections still open.
+func (s *sfu) close() {
+	close(s.done)
+
+	s.mu.Lock()
+	peers := s.peers
+	s.peers = make(map[string]*sfuPeer)
+	s.mu.Unlock()
+
+	for _, peer := range peers {
+		peer.pc.Close()
+	}
+}
+
+// addPeer creates the server side peer connection of c and sends c the first offer.
+func (s *sfu) addPeer(c *Client) error {
+	pc, err := webrtc.NewPeerConnection(s.config)
+	if err != nil {
+		return fmt.Errorf("failed to create peer connection: %w", err)
+	}
+
+	// accept one audio and one video track from the member
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		}); err != nil {
+			pc.Close()
+			return fmt.Errorf("failed to add %s transceiver: %w", kind, err)
+		}
+	}
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed {
+			pc.Close()
+		}
+	})
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		s.forward(c, remote)
+	})
+
+	peer := &sfuPeer{client: c, pc: pc}
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		// negotiate and handleOffer hold peer.mu until the description is sent, so the
+		// member never gets a candidate before the offer or answer it belongs to
+		peer.mu.Lock()
+		defer peer.mu.Unlock()
+
+		sendCandidate(c, s.room, SFUPeerID, candidate)
+	})
+
+	s.mu.Lock()
+	old := s.peers[c.ID]
+	s.peers[c.ID] = peer
+	s.mu.Unlock()
+
+	if old != nil {
+		old.pc.Close()
+	}
+
+	return s.negotiate(peer)
+}
+
+// removePeer drops c's peer connection. The connection is closed in the background,
+// the tracks c published go away once their forwarding stops.
+func (s *sfu) removePeer(c *Client) {
+	s.mu.Lock()
+	peer := s.peers[c.ID]
+	delete(s.peers, c.ID)
+	s.mu.Unlock()
+
+	if peer != nil {
+		go peer.pc.Close()
+	}
+}
+
+func (s *sfu) peer(sessionID string) *sfuPeer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.peers[sessionID]
+}
+
+// connection returns c's peer, it fails with ErrCodeNoPeerConnection if there is none.
+func (s *sfu) connection(c *Client) (*sfuPeer, error) {
+	peer := s.peer(c.ID)
+	if peer == nil {
+		return nil, NewHandlerError(ErrCodeNoPeerConnection, "no sfu peer connection, rejoin the room")
+	}
+	return peer, nil
+}
+
+// forward publishes remote to the other members until the member stops sending it.
+func (s *sfu) forward(c *Client, remote *webrtc.TrackRemote) {
+	// the stream ID is the publisher's session, so members can tell whose media a track is
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, c.ID+":"+remote.ID(), c.ID)
+	if err != nil {
+		c.logger().Error("sfu failed to create track", "room", s.room, "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	s.tracks[local.ID()] = &sfuTrack{local: local, owner: c.ID}
+	s.mu.Unlock()
+
+	s.signal()
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := remote.Read(buf)
+		if err != nil {
+			break
+		}
+
+		if _, err := local.Write(buf[:n]); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.tracks, local.ID())
+	s.mu.Unlock()
+
+	s.signal()
+}
+
+// signal renegotiates with every member so each one receives the current tracks.
+func (s *sfu) signal() {
+	s.mu.Lock()
+	peers := make([]*sfuPeer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, peer)
+	}
+	s.mu.Unlock()
+
+	for _, peer := range peers {
+		if err := s.negotiate(peer); err != nil {
+			peer.client.logger().Error("sfu failed to negotiate", "room", s.room, "error", err)
+		}
+	}
+}
+
+// negotiate syncs the tracks sent to peer and sends it a new offer. If an offer is
+// still unanswered the new one is sent once the answer arrives.
+func (s *sfu) negotiate(peer *sfuPeer) error {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+
+	if peer.pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return nil
+	}
+
+	if peer.awaitingAnswer {
+		peer.renegotiate = true
+		return nil
+	}
+
+	if err := s.syncTracks(peer); err != nil {
+		return err
+	}
+
+	offer, err := peer.pc.CreateOffer(&webrtc.OfferOptions{ICERestart: peer.iceRestart})
+	if err != nil {
+		return fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	if err := peer.pc.SetLocalDescription(offer); err != nil {
+		return fmt.Errorf("failed to set local description: %w", err)
+	}
+	peer.iceRestart = false
+	peer.awaitingAnswer = true
+
+	return s.send(peer.client, EventOffer, OfferEvent{
+		Room: s.room,
+		From: SFUPeerID,
+		To:   peer.client.ID,
+		Sdp:  peer.pc.LocalDescription().SDP,
+	})
+}
+
+// syncTracks adds the tracks of the other members that peer does not receive yet
+// and removes the ones that are gone. The caller must hold peer.mu.
+func (s *sfu) syncTracks(peer *sfuPeer) error {
+	s.mu.Lock()
+	tracks := make(map[string]*sfuTrack, len(s.tracks))
+	for id, track := range s.tracks {
+		tracks[id] = track
+	}
+	s.mu.Unlock()
+
+	sending := make(map[string]bool)
+	for _, sender := range peer.pc.GetSenders() {
+		if sender.Track() == nil {
+			continue
+		}
+
+		id := sender.Track().ID()
+		if _, ok := tracks[id]; !ok {
+			if err := peer.pc.RemoveTrack(sender); err != nil {
+				return fmt.Errorf("failed to remove track %s: %w", id, err)
+			}
+			continue
+		}
+		sending[id] = true
+	}
+
+	for id, track := range tracks {
+		if sending[id] || track.owner == peer.client.ID {
+			continue
+		}
+
+		sender, err := peer.pc.AddTrack(track.local)
+		if err != nil {
+			return fmt.Errorf("failed to add track %s: %w", id, err)
+		}
+
+		// read incoming RTCP so the interceptors keep working
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := sender.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// renegotiate sends c a new offer on request, restarting ICE if iceRestart is set.
+// If an offer is still unanswered the new one is sent once the answer arrives.
+// A peer connection that failed was closed, ICE cannot restart on it, so c is offered
+// a new one instead.
+func (s *sfu) renegotiate(c *Client, iceRestart bool) error {
+	peer, err := s.connection(c)
+	if err != nil {
+		return err
+	}
+
+	if peer.pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return s.addPeer(c)
+	}
+
+	if iceRestart {
+		peer.mu.Lock()
+		peer.iceRestart = true
+		peer.mu.Unlock()
+	}
+
+	return s.negotiate(peer)
+}
+
+// handleOffer answers an offer from the member, e.g. after it added a track.
+// While the server's own offer is unanswered the member's offer is refused.
+func (s *sfu) handleOffer(c *Client, sdp string) error {
+	peer, err := s.connection(c)
+	if err != nil {
+		return err
+	}
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+
+	if peer.awaitingAnswer {
+		return NewHandlerError(ErrCodeNegotiating, "the server's offer has to be answered first")
+	}
+
+	if err := peer.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}); err != nil {
+		return fmt.Errorf("failed to set remote description: %w", err)
+	}
+
+	answer, err := peer.pc.CreateAnswer(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create answer: %w", err)
+	}
+
+	if err := peer.pc.SetLocalDescription(answer); err != nil {
+		return fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	return s.send(c, EventAnswer, AnswerEvent{
+		Room: s.room,
+		From: SFUPeerID,
+		To:   c.ID,
+		Sdp:  peer.pc.LocalDescription().SDP,
+	})
+}
+
+// handleAnswer applies the member's answer to the server's last offer, and sends the
+// next offer if the tracks changed while it was outstanding.
+func (s *sfu) handleAnswer(c *Client, sdp string) error {
+	peer, err := s.connection(c)
+	if err != nil {
+		return err
+	}
+
+	peer.mu.Lock()
+	err = peer.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp})
+	peer.awaitingAnswer = false
+	renegotiate := peer.renegotiate
+	peer.renegotiate = false
+	peer.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to set remote description: %w", err)
+	}
+
+	if renegotiate {
+		return s.negotiate(peer)
+	}
+	return nil
+}
+
+// handleCandidate adds a trickled ICE candidate of the member.
+func (s *sfu) handleCandidate(c *Client, candidate json.RawMessage) error {
+	peer, err := s.connection(c)
+	if err != nil {
+		return err
+	}
+
+	var init webrtc.ICECandidateInit
+	if err := json.Unmarshal(candidate, &init); err != nil {
+		return NewHandlerError(ErrCodeBadRequest, fmt.Sprintf("bad ice candidate: %v", err))
+	}
+
+	return peer.pc.AddICECandidate(init)
+}
+
+// requestKeyframes periodically sends a picture loss indication for every published
+// track until the SFU is closed.
+func (s *sfu) requestKeyframes() {
+	ticker := time.NewTicker(keyframeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		peers := make([]*sfuPeer, 0, len(s.peers))
+		for _, peer := range s.peers {
+			peers = append(peers, peer)
+		}
+		s.mu.Unlock()
+
+		for _, peer := range peers {
+			for _, receiver := range peer.pc.GetReceivers() {
+				if receiver.Track() == nil {
+					continue
+				}
+
+				peer.pc.WriteRTCP([]rtcp.Packet{
+					&rtcp.PictureLossIndication{MediaSSRC: uint32(receiver.Track().SSRC())},
+				})
+			}
+		}
+	}
+}
+
+func (s *sfu) send(c *Client, eventType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %v", eventType, err)
+	}
+
+	return c.Send(c.Context(), Event{Type: eventType, Payload: data})
+}
+
+// sendCandidate trickles an ICE candidate the server gathered for its peer connection
+// with c, from is the server's peer ID. A nil candidate ends the gathering and is not sent.
+func sendCandidate(c *Client, room, from string, candidate *webrtc.ICECandidate) {
+	if candidate == nil {
+		return
+	}
+
+	data, err := json.Marshal(candidate.ToJSON())
+	if err != nil {
+		c.logger().Error("failed to marshal ice candidate", "room", room, "from", from, "error", err)
+		return
+	}
+
+	payload, err := json.Marshal(IceCandidateEvent{
+		Room:      room,
+		From:      from,
+		To:        c.ID,
+		Candidate: data,
+	})
+	if err != nil {
+		c.logger().Error("failed to marshal ice candidate event", "room", room, "from", from, "error", err)
+		return
+	}
+
+	if err := c.Send(c.Context(), Event{Type: EventIceCandidate, Payload: payload}); err != nil {
+		c.logger().Debug("failed to send ice candidate", "room", room, "from", from, "error", err)
+	}
+}
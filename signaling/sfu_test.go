@@ -0,0 +1,79 @@
+package signaling
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestSFUICERestartReplacesClosedPeer(t *testing.T) {
+	c := newTestClient(t, nil)
+	s := newSFU("lobby", webrtc.Configuration{})
+	defer s.close()
+
+	if err := s.addPeer(c); err != nil {
+		t.Fatalf("addPeer: %v", err)
+	}
+	failed := s.peer(c.ID)
+	// the SFU closes a peer connection once it failed
+	failed.pc.Close()
+	c.egress.pop()
+
+	if err := s.renegotiate(c, true); err != nil {
+		t.Fatalf("renegotiate: %v", err)
+	}
+
+	peer := s.peer(c.ID)
+	if peer == failed {
+		t.Fatal("ice_restart kept the closed peer connection")
+	}
+	if state := peer.pc.ConnectionState(); state == webrtc.PeerConnectionStateClosed {
+		t.Fatalf("new peer connection is %s", state)
+	}
+	if offer, ok := c.egress.pop(); !ok || offer.Type != EventOffer {
+		t.Fatalf("queued %q after ice_restart, want an offer", offer.Type)
+	}
+}
+
+func TestSFUTricklesCandidatesAfterOffer(t *testing.T) {
+	c := newTestClient(t, nil)
+	s := newSFU("lobby", webrtc.Configuration{})
+	defer s.close()
+
+	if err := s.addPeer(c); err != nil {
+		t.Fatalf("addPeer: %v", err)
+	}
+
+	// addPeer no longer waits for gathering, the candidates follow the offer
+	if offer, ok := c.egress.pop(); !ok || offer.Type != EventOffer {
+		t.Fatalf("first queued event is %q, want an offer", offer.Type)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case <-c.egress.ready:
+		case <-deadline:
+			t.Fatal("no ice candidate trickled")
+		}
+
+		event, ok := c.egress.pop()
+		if !ok {
+			continue
+		}
+		if event.Type != EventIceCandidate {
+			t.Fatalf("queued %q, want %q", event.Type, EventIceCandidate)
+		}
+
+		var candidate IceCandidateEvent
+		if err := json.Unmarshal(event.Payload, &candidate); err != nil {
+			t.Fatalf("unmarshal candidate: %v", err)
+		}
+		if candidate.From != SFUPeerID || candidate.To != c.ID {
+			t.Fatalf("candidate from %q to %q, want from %q to %q", candidate.From, candidate.To, SFUPeerID, c.ID)
+		}
+		return
+	}
+}
@@ -0,0 +1,234 @@
+package signaling
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zenk41/learn-webrtc/signaling/config"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// selfSignedValidity is how long a generated self-signed certificate is valid.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// NewTLSConfig returns the TLS configuration the server is started with for the mode in
+// cfg, nil when TLS is off. In acme mode it also returns the handler that answers HTTP-01
+// challenges and redirects everything else to HTTPS, it is nil in the other modes.
+func NewTLSConfig(cfg config.ServerConfig) (*tls.Config, http.Handler, error) {
+	switch cfg.TLS_MODE {
+	case config.TLSModeOff:
+		return nil, nil, nil
+	case config.TLSModeSelfSigned:
+		if err := ensureSelfSigned(cfg.TLS_CERT_FILE, cfg.TLS_KEY_FILE, cfg.TLS_HOSTS); err != nil {
+			return nil, nil, err
+		}
+		return fileTLSConfig(cfg.TLS_CERT_FILE, cfg.TLS_KEY_FILE)
+	case config.TLSModeFile:
+		return fileTLSConfig(cfg.TLS_CERT_FILE, cfg.TLS_KEY_FILE)
+	case config.TLSModeACME:
+		return acmeTLSConfig(cfg)
+	default:
+		return nil, nil, fmt.Errorf("unknown tls mode %q", cfg.TLS_MODE)
+	}
+}
+
+func fileTLSConfig(certFile, keyFile string) (*tls.Config, http.Handler, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load tls certificate: %w", err)
+	}
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}, nil, nil
+}
+
+// acmeTLSConfig obtains certificates on demand through autocert, which caches them in
+// ACME_CACHE_DIR and renews them before they expire.
+func acmeTLSConfig(cfg config.ServerConfig) (*tls.Config, http.Handler, error) {
+	client := &acme.Client{DirectoryURL: cfg.ACME_DIRECTORY_URL}
+	if cfg.ACME_CA_FILE != "" {
+		transport, err := caTransport(cfg.ACME_CA_FILE)
+		if err != nil {
+			return nil, nil, err
+		}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.ACME_CACHE_DIR),
+		HostPolicy: autocert.HostWhitelist(cfg.ACME_DOMAINS...),
+		Email:      cfg.ACME_EMAIL,
+		Client:     client,
+	}
+
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	return tlsConfig, manager.HTTPHandler(nil), nil
+}
+
+// caTransport returns a transport that also trusts the PEM certificates in caFile, for
+// ACME servers like Pebble whose certificate is not signed by a public root.
+func caTransport(caFile string) (*http.Transport, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read acme ca file: %w", err)
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	return transport, nil
+}
+
+// ensureSelfSigned generates an ECDSA P-256 certificate for hosts and writes it to
+// certFile and keyFile unless both already exist. It fails if only one of them exists,
+// rather than replacing a key or certificate that may be in use elsewhere.
+func ensureSelfSigned(certFile, keyFile string, hosts []string) error {
+	certExists, err := fileExists(certFile)
+	if err != nil {
+		return err
+	}
+	keyExists, err := fileExists(keyFile)
+	if err != nil {
+		return err
+	}
+	if certExists && keyExists {
+		return nil
+	}
+	if certExists {
+		return fmt.Errorf("tls cert file %s exists but key file %s does not, provide both or remove it", certFile, keyFile)
+	}
+	if keyExists {
+		return fmt.Errorf("tls key file %s exists but cert file %s does not, provide both or remove it", keyFile, certFile)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	notBefore := time.Now().Add(-time.Hour)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"learn-webrtc"}, CommonName: hosts[0]},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key: %w", err)
+	}
+
+	// both files are written in full before either is renamed into place, so a crash
+	// leaves no truncated file behind
+	keyTemp, err := writePEMTemp(keyFile, "PRIVATE KEY", keyDER, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyTemp)
+	certTemp, err := writePEMTemp(certFile, "CERTIFICATE", der, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(certTemp)
+
+	if err := os.Rename(keyTemp, keyFile); err != nil {
+		return fmt.Errorf("failed to write %s: %w", keyFile, err)
+	}
+	if err := os.Rename(certTemp, certFile); err != nil {
+		os.Remove(keyFile)
+		return fmt.Errorf("failed to write %s: %w", certFile, err)
+	}
+
+	slog.Info("generated self-signed certificate", "cert_file", certFile, "hosts", hosts)
+	return nil
+}
+
+// writePEMTemp writes der as a PEM block to a temporary file next to path and returns
+// the temporary file's name, the caller renames it to path.
+func writePEMTemp(path, blockType string, der []byte, perm os.FileMode) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	name := file.Name()
+
+	err = file.Chmod(perm)
+	if err == nil {
+		err = pem.Encode(file, &pem.Block{Type: blockType, Bytes: der})
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return name, nil
+}
+
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to stat %s: %w", path, err)
+}
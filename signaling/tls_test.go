@@ -0,0 +1,172 @@
+package signaling
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/challtestsrv"
+	"github.com/letsencrypt/pebble/v2/ca"
+	"github.com/letsencrypt/pebble/v2/db"
+	"github.com/letsencrypt/pebble/v2/va"
+	"github.com/letsencrypt/pebble/v2/wfe"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+)
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls", "server.crt")
+	keyFile := filepath.Join(dir, "tls", "server.key")
+
+	if err := ensureSelfSigned(certFile, keyFile, []string{"localhost", "127.0.0.1"}); err != nil {
+		t.Fatalf("ensureSelfSigned: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load generated pair: %v", err)
+	}
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("key file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(certFile)); len(entries) != 2 {
+		t.Fatalf("%d files next to the pair, want only the pair", len(entries))
+	}
+
+	// an existing pair is kept
+	if err := ensureSelfSigned(certFile, keyFile, []string{"localhost"}); err != nil {
+		t.Fatalf("ensureSelfSigned again: %v", err)
+	}
+	again, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load pair: %v", err)
+	}
+	if string(again.Certificate[0]) != string(cert.Certificate[0]) {
+		t.Fatal("existing certificate was replaced")
+	}
+}
+
+func TestEnsureSelfSignedRefusesHalfAPair(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	if err := os.WriteFile(keyFile, []byte("key in use"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureSelfSigned(certFile, keyFile, []string{"localhost"}); err == nil {
+		t.Fatal("ensureSelfSigned generated a certificate next to an existing key")
+	}
+	if data, _ := os.ReadFile(keyFile); string(data) != "key in use" {
+		t.Fatal("existing key was overwritten")
+	}
+}
+
+// listenLocal listens on a random loopback port and returns the listener and its port.
+func listenLocal(t *testing.T) (net.Listener, int) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln, ln.Addr().(*net.TCPAddr).Port
+}
+
+// TestACMEWithPebble obtains a certificate from an in-process Pebble, Let's Encrypt's
+// test CA, which validates the challenges against the server's listeners.
+func TestACMEWithPebble(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs an ACME issuance")
+	}
+	t.Setenv("PEBBLE_VA_NOSLEEP", "1")
+	t.Setenv("PEBBLE_WFE_NONCEREJECT", "0")
+	const domain = "signaling.test"
+	logger := log.New(io.Discard, "", 0)
+
+	// every name resolves to 127.0.0.1 for Pebble's validation
+	dnsAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(freeUDPPort(t)))
+	dns, err := challtestsrv.New(challtestsrv.Config{DNSOneAddrs: []string{dnsAddr}, Log: logger})
+	if err != nil {
+		t.Fatalf("challtestsrv: %v", err)
+	}
+	dns.SetDefaultDNSIPv6("")
+	go dns.Run()
+	t.Cleanup(dns.Shutdown)
+
+	httpsListener, tlsPort := listenLocal(t)
+	httpListener, httpPort := listenLocal(t)
+
+	store := db.NewMemoryStore()
+	authority := ca.New(logger, store, "", 0, 1, 0)
+	validator := va.New(logger, httpPort, tlsPort, false, dnsAddr, store)
+	frontend := wfe.New(logger, store, validator, authority, false, false, 0, 0)
+
+	pebble := httptest.NewTLSServer(frontend.Handler())
+	t.Cleanup(pebble.Close)
+	caFile := filepath.Join(t.TempDir(), "pebble.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pebble.Certificate().Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, challengeHandler, err := NewTLSConfig(config.ServerConfig{
+		TLS_MODE:           config.TLSModeACME,
+		ACME_DOMAINS:       []string{domain},
+		ACME_CACHE_DIR:     t.TempDir(),
+		ACME_DIRECTORY_URL: pebble.URL + "/dir",
+		ACME_CA_FILE:       caFile,
+	})
+	if err != nil {
+		t.Fatalf("NewTLSConfig: %v", err)
+	}
+
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	httpsServer := &http.Server{Handler: okHandler, TLSConfig: tlsConfig}
+	go httpsServer.ServeTLS(httpsListener, "", "")
+	t.Cleanup(func() { httpsServer.Close() })
+	httpServer := &http.Server{Handler: challengeHandler}
+	go httpServer.Serve(httpListener)
+	t.Cleanup(func() { httpServer.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.GetRootCert(0).Cert)
+	client := &http.Client{
+		Timeout: time.Minute,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: domain},
+		},
+	}
+
+	// the first handshake obtains the certificate, it verifies against Pebble's root
+	resp, err := client.Get("https://" + httpsListener.Addr().String())
+	if err != nil {
+		t.Fatalf("GET over acme certificate: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if names := resp.TLS.PeerCertificates[0].DNSNames; len(names) != 1 || names[0] != domain {
+		t.Fatalf("certificate names = %v, want [%s]", names, domain)
+	}
+}
+
+// freeUDPPort returns a loopback UDP port that was free a moment ago.
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
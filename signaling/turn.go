@@ -0,0 +1,201 @@
+package signaling
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v4"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+)
+
+// TURNServer is a TURN/STUN server embedded next to the signaling server, it relays
+// media for peers that cannot reach each other directly, e.g. behind symmetric NATs.
+//
+// Clients authenticate with TURN REST credentials: the username is "expiry:user"
+// and the password is the base64 HMAC-SHA1 of that username under the shared secret.
+type TURNServer struct {
+	server *turn.Server
+	secret string
+
+	maxAllocations int
+
+	mu sync.Mutex
+	// allocations counts the open relay allocations of each user.
+	allocations map[string]int
+}
+
+// NewTURNServer starts listening on the configured UDP and TCP ports.
+// The server runs until Close is called.
+func NewTURNServer(cfg config.TURNConfig) (*TURNServer, error) {
+	if cfg.SECRET == "" {
+		return nil, errors.New("turn secret is required")
+	}
+
+	relayIP := net.ParseIP(cfg.PUBLIC_IP)
+	if relayIP == nil {
+		return nil, fmt.Errorf("turn public ip %q is not an ip address", cfg.PUBLIC_IP)
+	}
+
+	if cfg.RELAY_MIN_PORT <= 0 || cfg.RELAY_MAX_PORT > 65535 || cfg.RELAY_MIN_PORT > cfg.RELAY_MAX_PORT {
+		return nil, fmt.Errorf("invalid turn relay port range %d-%d", cfg.RELAY_MIN_PORT, cfg.RELAY_MAX_PORT)
+	}
+
+	if cfg.UDP_PORT <= 0 && cfg.TCP_PORT <= 0 {
+		return nil, errors.New("turn needs a udp or tcp port")
+	}
+
+	s := &TURNServer{
+		secret:         cfg.SECRET,
+		maxAllocations: cfg.MAX_ALLOCATIONS_PER_USER,
+		allocations:    make(map[string]int),
+	}
+
+	relayAddressGenerator := func() turn.RelayAddressGenerator {
+		return &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: relayIP,
+			Address:      cfg.LISTEN_ADDR,
+			MinPort:      uint16(cfg.RELAY_MIN_PORT),
+			MaxPort:      uint16(cfg.RELAY_MAX_PORT),
+		}
+	}
+
+	serverConfig := turn.ServerConfig{
+		Realm:        cfg.REALM,
+		AuthHandler:  s.authenticate,
+		QuotaHandler: s.withinQuota,
+		EventHandler: turn.EventHandler{
+			OnAllocationCreated: s.allocationCreated,
+			OnAllocationDeleted: s.allocationDeleted,
+		},
+	}
+
+	// closers releases the sockets opened so far if starting the server fails.
+	var closers []func() error
+	closeAll := func() {
+		for _, closer := range closers {
+			closer()
+		}
+	}
+
+	if cfg.UDP_PORT > 0 {
+		conn, err := net.ListenPacket("udp4", net.JoinHostPort(cfg.LISTEN_ADDR, strconv.Itoa(cfg.UDP_PORT)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for turn on udp: %w", err)
+		}
+		closers = append(closers, conn.Close)
+
+		serverConfig.PacketConnConfigs = append(serverConfig.PacketConnConfigs, turn.PacketConnConfig{
+			PacketConn:            conn,
+			RelayAddressGenerator: relayAddressGenerator(),
+		})
+	}
+
+	if cfg.TCP_PORT > 0 {
+		listener, err := net.Listen("tcp4", net.JoinHostPort(cfg.LISTEN_ADDR, strconv.Itoa(cfg.TCP_PORT)))
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to listen for turn on tcp: %w", err)
+		}
+		closers = append(closers, listener.Close)
+
+		serverConfig.ListenerConfigs = append(serverConfig.ListenerConfigs, turn.ListenerConfig{
+			Listener:              listener,
+			RelayAddressGenerator: relayAddressGenerator(),
+		})
+	}
+
+	server, err := turn.NewServer(serverConfig)
+	if err != nil {
+		closeAll()
+		return nil, fmt.Errorf("failed to start turn server: %w", err)
+	}
+	s.server = server
+
+	slog.Info("TURN server listening", "udp_port", cfg.UDP_PORT, "tcp_port", cfg.TCP_PORT,
+		"relay_ip", relayIP, "relay_min_port", cfg.RELAY_MIN_PORT, "relay_max_port", cfg.RELAY_MAX_PORT)
+
+	return s, nil
+}
+
+// Close stops the listeners and releases every open allocation.
+func (s *TURNServer) Close() error {
+	return s.server.Close()
+}
+
+// authenticate checks a TURN REST username and returns the long-term credential key
+// for it. Expired usernames are rejected.
+func (s *TURNServer) authenticate(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+	expiry, _, ok := strings.Cut(username, ":")
+	if !ok {
+		return nil, false
+	}
+
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return nil, false
+	}
+
+	return turn.GenerateAuthKey(username, realm, turnPassword(s.secret, username)), true
+}
+
+// withinQuota reports whether the user may open another relay allocation.
+func (s *TURNServer) withinQuota(username, realm string, srcAddr net.Addr) bool {
+	if s.maxAllocations <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.allocations[turnUser(username)] < s.maxAllocations
+}
+
+func (s *TURNServer) allocationCreated(srcAddr, dstAddr net.Addr, protocol, username, realm string,
+	relayAddr net.Addr, requestedPort int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.allocations[turnUser(username)]++
+}
+
+func (s *TURNServer) allocationDeleted(srcAddr, dstAddr net.Addr, protocol, username, realm string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := turnUser(username)
+	if s.allocations[user] <= 1 {
+		delete(s.allocations, user)
+		return
+	}
+	s.allocations[user]--
+}
+
+// TURNCredentials mints TURN REST credentials for user that the TURN server accepts
+// until expiresAt, as long as both are configured with the same secret.
+func TURNCredentials(secret, user string, ttl time.Duration) (username, password string, expiresAt time.Time) {
+	expiresAt = time.Now().Add(ttl)
+	username = strconv.FormatInt(expiresAt.Unix(), 10) + ":" + user
+	return username, turnPassword(secret, username), expiresAt
+}
+
+// turnUser returns the user part of a TURN REST username.
+func turnUser(username string) string {
+	_, user, _ := strings.Cut(username, ":")
+	return user
+}
+
+// turnPassword returns the TURN REST password for username under secret.
+func turnPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
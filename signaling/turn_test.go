@@ -0,0 +1,186 @@
+package signaling
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v4"
+	"github.com/zenk41/learn-webrtc/signaling/config"
+)
+
+func TestTURNPassword(t *testing.T) {
+	// the base64 HMAC-SHA1 of the username under the secret, as coturn computes it
+	if got := turnPassword("secret", "1700000000:alice"); got != "d8soP47RbdIKLDUOpnJPVQyq5Ts=" {
+		t.Fatalf("turnPassword = %q", got)
+	}
+}
+
+func TestTURNCredentials(t *testing.T) {
+	before := time.Now()
+	username, password, expiresAt := TURNCredentials("secret", "alice", time.Hour)
+
+	expiry, user, ok := strings.Cut(username, ":")
+	if !ok || user != "alice" {
+		t.Fatalf("username %q is not expiry:user", username)
+	}
+	if expiry != strconv.FormatInt(expiresAt.Unix(), 10) {
+		t.Fatalf("username expires at %s, the credentials at %d", expiry, expiresAt.Unix())
+	}
+	if want := before.Add(time.Hour); expiresAt.Before(want.Add(-time.Second)) || expiresAt.After(want.Add(time.Second)) {
+		t.Fatalf("credentials expire at %s, want about %s", expiresAt, want)
+	}
+	if password != turnPassword("secret", username) {
+		t.Fatalf("password %q is not the one of the username", password)
+	}
+}
+
+func TestTURNAuthenticate(t *testing.T) {
+	s := &TURNServer{secret: "secret", allocations: make(map[string]int)}
+	const realm = "learn-webrtc"
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+
+	tests := []struct {
+		name     string
+		username string
+		secret   string
+		wantOK   bool
+		wantKey  bool
+	}{
+		{name: "valid", username: future + ":alice", secret: "secret", wantOK: true, wantKey: true},
+		{name: "expired", username: past + ":alice", secret: "secret"},
+		{name: "no expiry", username: "alice", secret: "secret"},
+		{name: "malformed expiry", username: "soon:alice", secret: "secret"},
+		// the server answers with its key, the client's integrity check then fails
+		{name: "other secret", username: future + ":alice", secret: "guess", wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := s.authenticate(tt.username, realm, nil)
+			if ok != tt.wantOK {
+				t.Fatalf("authenticate ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+
+			clientKey := turn.GenerateAuthKey(tt.username, realm, turnPassword(tt.secret, tt.username))
+			if matches := string(key) == string(clientKey); matches != tt.wantKey {
+				t.Fatalf("key matches the client's = %v, want %v", matches, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestTURNQuota(t *testing.T) {
+	tests := []struct {
+		name      string
+		max       int
+		created   []string
+		deleted   []string
+		username  string
+		wantAllow bool
+	}{
+		{name: "below quota", max: 2, created: []string{"1:alice"}, username: "2:alice", wantAllow: true},
+		{name: "at quota", max: 2, created: []string{"1:alice", "2:alice"}, username: "3:alice"},
+		{name: "other user", max: 1, created: []string{"1:alice"}, username: "1:bob", wantAllow: true},
+		{name: "after delete", max: 1, created: []string{"1:alice"}, deleted: []string{"1:alice"}, username: "2:alice", wantAllow: true},
+		{name: "unlimited", max: 0, created: []string{"1:alice", "2:alice"}, username: "3:alice", wantAllow: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &TURNServer{secret: "secret", maxAllocations: tt.max, allocations: make(map[string]int)}
+			for _, username := range tt.created {
+				s.allocationCreated(nil, nil, "udp", username, "", nil, 0)
+			}
+			for _, username := range tt.deleted {
+				s.allocationDeleted(nil, nil, "udp", username, "")
+			}
+
+			if allow := s.withinQuota(tt.username, "", nil); allow != tt.wantAllow {
+				t.Fatalf("withinQuota = %v, want %v", allow, tt.wantAllow)
+			}
+		})
+	}
+}
+
+// allocateTURN allocates a relay on the TURN server at addr with the given credentials.
+func allocateTURN(t *testing.T, addr, username, password string) (net.PacketConn, error) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: addr,
+		TURNServerAddr: addr,
+		Conn:           conn,
+		Username:       username,
+		Password:       password,
+		RTO:            100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("turn client: %v", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		conn.Close()
+	})
+	if err := client.Listen(); err != nil {
+		t.Fatalf("turn client listen: %v", err)
+	}
+
+	return client.Allocate()
+}
+
+func TestTURNServerAllocations(t *testing.T) {
+	port := freeUDPPort(t)
+	s, err := NewTURNServer(config.TURNConfig{
+		REALM:                    "learn-webrtc",
+		LISTEN_ADDR:              "127.0.0.1",
+		PUBLIC_IP:                "127.0.0.1",
+		UDP_PORT:                 port,
+		RELAY_MIN_PORT:           40000,
+		RELAY_MAX_PORT:           40100,
+		SECRET:                   "secret",
+		MAX_ALLOCATIONS_PER_USER: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewTURNServer: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	username, password, _ := TURNCredentials("secret", "alice", time.Hour)
+	relay, err := allocateTURN(t, addr, username, password)
+	if err != nil {
+		t.Fatalf("allocate with valid credentials: %v", err)
+	}
+	defer relay.Close()
+
+	// alice is at her quota, bob is not
+	if _, err := allocateTURN(t, addr, username, password); err == nil {
+		t.Fatal("a second allocation over the quota was granted")
+	}
+	bob, bobPassword, _ := TURNCredentials("secret", "bob", time.Hour)
+	if relay, err := allocateTURN(t, addr, bob, bobPassword); err != nil {
+		t.Fatalf("allocate for another user: %v", err)
+	} else {
+		relay.Close()
+	}
+
+	expired, expiredPassword, _ := TURNCredentials("secret", "carol", -time.Minute)
+	if _, err := allocateTURN(t, addr, expired, expiredPassword); err == nil {
+		t.Fatal("expired credentials were accepted")
+	}
+	forged, _, _ := TURNCredentials("secret", "mallory", time.Hour)
+	if _, err := allocateTURN(t, addr, forged, "guess"); err == nil {
+		t.Fatal("credentials signed with another secret were accepted")
+	}
+}